@@ -0,0 +1,114 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+)
+
+/* -------------------- ALL countries dataset -------------------- */
+
+const defaultAllFetchTimeout = 30 * time.Second
+
+var (
+	// The full dataset is large and changes rarely, so one copy is kept for an hour.
+	allCountriesCache = newTTLCache[[]countriesCountry](time.Hour, 1)
+
+	// The /all payload is much larger than a single country, so it gets its own
+	// deadline (ALL_FETCH_TIMEOUT) via the request context instead of httpClient's.
+	bulkClient = &http.Client{}
+)
+
+type allFetch struct {
+	countries []countriesCountry
+	status    int
+}
+
+var allFlight = newFlightGroup[allFetch]()
+
+// getAllCountries returns the cached /all dataset, fetching it on a miss.
+// Concurrent misses (from any of the aggregate endpoints) share one download.
+func getAllCountries(ctx context.Context) ([]countriesCountry, int, error) {
+	if all, ok := allCountriesCache.get("all"); ok {
+		return all, http.StatusOK, nil
+	}
+
+	res, err := allFlight.do(ctx, "all", LoadConfig().AllFetchTimeout, func(ctx context.Context) (allFetch, error) {
+		all, st, err := fetchAllCountries(ctx)
+		if err != nil || st != http.StatusOK {
+			return allFetch{status: st}, err
+		}
+		allCountriesCache.set("all", all)
+		return allFetch{countries: all, status: http.StatusOK}, nil
+	})
+	if err != nil || res.status != http.StatusOK {
+		return nil, res.status, err
+	}
+	return res.countries, http.StatusOK, nil
+}
+
+// fetchAllCountries downloads /all; getAllCountries bounds it with
+// ALL_FETCH_TIMEOUT.
+func fetchAllCountries(ctx context.Context) ([]countriesCountry, int, error) {
+	url := fmt.Sprintf("%s/all", countriesBaseURL())
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+	if err != nil {
+		return nil, 0, err
+	}
+	resp, err := doUpstream(bulkClient, req)
+	if err != nil {
+		return nil, 0, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, resp.StatusCode, nil
+	}
+
+	var all []countriesCountry
+	if err := json.NewDecoder(resp.Body).Decode(&all); err != nil {
+		return nil, 0, &upstreamDecodeError{service: "countries", err: err}
+	}
+	return all, http.StatusOK, nil
+}
+
+// exchangeRegions are the upstream's regions, lowercased.
+var exchangeRegions = []string{"africa", "americas", "antarctic", "asia", "europe", "oceania"}
+
+// countriesInRegion returns pointers to the countries of region (matched
+// case-insensitively) in the dataset order, leaving out the country exclude
+// (a cca3 code). all is not modified.
+func countriesInRegion(all []countriesCountry, region, exclude string) []*countriesCountry {
+	var out []*countriesCountry
+	for i := range all {
+		if strings.EqualFold(all[i].Region, region) && all[i].CCA3 != exclude {
+			out = append(out, &all[i])
+		}
+	}
+	return out
+}
+
+// isIndependent treats a missing "independent" flag as not independent.
+func isIndependent(c *countriesCountry) bool {
+	return c.Independent != nil && *c.Independent
+}
+
+// independentOnly reports whether a list endpoint was asked to exclude
+// dependencies and territories (?independentOnly=true).
+func independentOnly(r *http.Request) bool {
+	return r.URL.Query().Get("independentOnly") == "true"
+}
+
+// filterIndependent returns the sovereign states in all, without modifying it.
+func filterIndependent(all []countriesCountry) []countriesCountry {
+	out := make([]countriesCountry, 0, len(all))
+	for i := range all {
+		if isIndependent(&all[i]) {
+			out = append(out, all[i])
+		}
+	}
+	return out
+}
@@ -0,0 +1,106 @@
+package main
+
+import (
+	"net/http"
+	"strings"
+	"testing"
+)
+
+// A country the upstream says nothing about: no independent or unMember flag
+const fixtureUnflagged = `{"name":{"common":"Bouvet Island"},"cca2":"BV","cca3":"BVT","continents":["Antarctica"],"region":"Antarctic",` +
+	`"population":0,"area":49,"languages":{},"capital":[],"currencies":{}}`
+
+// The info response passes the upstream flags through, null when missing.
+func TestInfoIndependentFlags(t *testing.T) {
+	countries := newUpstreamStub(t, countriesStubHandler(t, fixtureNorway, fixtureGreenland, fixtureUnflagged))
+	useUpstreams(t, countries, nil, nil)
+
+	tests := []struct {
+		code            string
+		wantIndependent *bool
+		wantUNMember    *bool
+	}{
+		{"no", ptr(true), ptr(true)},
+		{"gl", ptr(false), ptr(false)},
+		{"bv", nil, nil},
+	}
+	for _, tt := range tests {
+		t.Run(tt.code, func(t *testing.T) {
+			var resp struct {
+				Independent *bool `json:"independent"`
+				UNMember    *bool `json:"un_member"`
+			}
+			getJSON(t, InfoHandler, "/countryinfo/v1/info/"+tt.code, http.StatusOK, &resp)
+			if !equalBoolPtr(resp.Independent, tt.wantIndependent) {
+				t.Errorf("independent %v, want %v", fmtBoolPtr(resp.Independent), fmtBoolPtr(tt.wantIndependent))
+			}
+			if !equalBoolPtr(resp.UNMember, tt.wantUNMember) {
+				t.Errorf("un_member %v, want %v", fmtBoolPtr(resp.UNMember), fmtBoolPtr(tt.wantUNMember))
+			}
+		})
+	}
+}
+
+// ?independentOnly=true drops dependencies (Greenland) and countries without
+// the flag (Bouvet Island) from every list endpoint, and nothing else.
+func TestIndependentOnlyFilter(t *testing.T) {
+	countries := newUpstreamStub(t, countriesStubHandler(t, fixtureNorway, fixtureFinland, fixtureGreenland, fixtureUnflagged))
+	useUpstreams(t, countries, nil, nil)
+
+	tests := []struct {
+		name    string
+		handler http.HandlerFunc
+		target  string
+		kept    string // an independent state in the unfiltered result
+		dropped []string
+	}{
+		{"list", ListHandler, "/countryinfo/v1/list?sort=name", "Norway", []string{"Greenland", "Bouvet Island"}},
+		{"search", SearchHandler, "/countryinfo/v1/search?name=land", "Finland", []string{"Greenland", "Bouvet Island"}},
+		{"fuzzy", FuzzyHandler, "/countryinfo/v1/fuzzy/finlnd?maxDistance=5", "Finland", []string{"Greenland"}},
+		{"top", TopHandler, "/countryinfo/v1/top?metric=area", "Norway", []string{"Greenland", "Bouvet Island"}},
+		{"currency usage", CurrencyUsageHandler, "/countryinfo/v1/currency-usage", "NOK", []string{"DKK"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			sep := "?"
+			if strings.Contains(tt.target, "?") {
+				sep = "&"
+			}
+			for _, filtered := range []bool{false, true} {
+				target := tt.target
+				if filtered {
+					target += sep + "independentOnly=true"
+				}
+				rec := serve(tt.handler, http.MethodGet, target, nil)
+				if rec.Code != http.StatusOK {
+					t.Fatalf("GET %s: status %d; body %s", target, rec.Code, rec.Body)
+				}
+				body := rec.Body.String()
+				if !strings.Contains(body, tt.kept) {
+					t.Errorf("GET %s: %s missing", target, tt.kept)
+				}
+				for _, name := range tt.dropped {
+					if got := strings.Contains(body, name); got == filtered {
+						t.Errorf("GET %s: contains %s = %t, want %t", target, name, got, !filtered)
+					}
+				}
+			}
+		})
+	}
+}
+
+func ptr[T any](v T) *T { return &v }
+
+func equalBoolPtr(a, b *bool) bool {
+	return (a == nil && b == nil) || (a != nil && b != nil && *a == *b)
+}
+
+func fmtBoolPtr(b *bool) string {
+	if b == nil {
+		return "null"
+	}
+	if *b {
+		return "true"
+	}
+	return "false"
+}
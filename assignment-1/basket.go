@@ -0,0 +1,120 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math"
+	"net/http"
+	"strings"
+)
+
+/* -------------------- BASKET endpoint -------------------- */
+
+const (
+	maxBasketItems     = 50
+	maxBasketBodyBytes = 64 << 10
+)
+
+type basketRequest struct {
+	Base  string       `json:"base"`
+	Items []basketItem `json:"items"`
+}
+
+type basketItem struct {
+	Currency string  `json:"currency"`
+	Amount   float64 `json:"amount"`
+}
+
+type basketConversion struct {
+	Currency  string  `json:"currency"`
+	Amount    float64 `json:"amount"`
+	Rate      float64 `json:"rate"`      // units of currency per 1 base
+	Converted float64 `json:"converted"` // amount expressed in base
+}
+
+type basketResponse struct {
+	Base  string             `json:"base"`
+	Total float64            `json:"total"`
+	Items []basketConversion `json:"items"`
+}
+
+// BasketHandler serves POST /countryinfo/v1/basket
+// {"base":"NOK","items":[{"currency":"SEK","amount":500}]}
+func BasketHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		writeJSONError(w, http.StatusMethodNotAllowed, "method not allowed")
+		return
+	}
+	if !checkQueryParams(w, r) {
+		return
+	}
+
+	var req basketRequest
+	if err := json.NewDecoder(http.MaxBytesReader(w, r.Body, maxBasketBodyBytes)).Decode(&req); err != nil {
+		writeJSONError(w, http.StatusBadRequest, "body must be JSON like {\"base\":\"NOK\",\"items\":[{\"currency\":\"SEK\",\"amount\":500}]}")
+		return
+	}
+
+	base := strings.ToUpper(strings.TrimSpace(req.Base))
+	if !validCurrencyCode(base) {
+		writeJSONError(w, http.StatusBadRequest, "base must be a 3-letter currency code")
+		return
+	}
+	if len(req.Items) == 0 || len(req.Items) > maxBasketItems {
+		writeJSONError(w, http.StatusBadRequest, fmt.Sprintf("items must contain between 1 and %d entries", maxBasketItems))
+		return
+	}
+	for i := range req.Items {
+		it := &req.Items[i]
+		it.Currency = strings.ToUpper(strings.TrimSpace(it.Currency))
+		if !validCurrencyCode(it.Currency) {
+			writeJSONError(w, http.StatusBadRequest, fmt.Sprintf("items[%d].currency must be a 3-letter currency code", i))
+			return
+		}
+		if it.Amount < 0 || math.IsNaN(it.Amount) || math.IsInf(it.Amount, 0) {
+			writeJSONError(w, http.StatusBadRequest, fmt.Sprintf("items[%d].amount must be a non-negative number", i))
+			return
+		}
+	}
+
+	out := basketResponse{Base: base, Items: make([]basketConversion, 0, len(req.Items))}
+	for _, it := range req.Items {
+		rate := 1.0
+		if it.Currency != base {
+			v, found, st, err := lookupRate(r.Context(), base, it.Currency)
+			if errors.Is(err, errRatesNotSuccess) {
+				writeJSONError(w, http.StatusBadGateway, err.Error())
+				return
+			}
+			if err != nil {
+				writeRatesError(w, err)
+				return
+			}
+			if st == http.StatusNotFound {
+				writeJSONError(w, http.StatusNotFound, "base currency not supported by currency service: "+base)
+				return
+			}
+			if st != http.StatusOK {
+				writeJSONError(w, http.StatusBadGateway, "currency service returned non-200")
+				return
+			}
+			if !found || v <= 0 {
+				writeJSONError(w, http.StatusNotFound, "no rate from "+base+" to "+it.Currency)
+				return
+			}
+			rate = v
+		}
+
+		converted := it.Amount / rate
+		out.Total += converted
+		out.Items = append(out.Items, basketConversion{
+			Currency:  it.Currency,
+			Amount:    it.Amount,
+			Rate:      rate,
+			Converted: converted,
+		})
+	}
+
+	writeJSON(w, http.StatusOK, out)
+}
@@ -0,0 +1,45 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+// A rate table the currency service marks as failed is a 502, not a 404 for
+// a currency missing from it.
+func TestBasketRateTableResult(t *testing.T) {
+	const body = `{"base":"NOK","items":[{"currency":"SEK","amount":100}]}`
+	tests := []struct {
+		name       string
+		currency   http.HandlerFunc
+		wantStatus int
+		wantError  string
+	}{
+		{"success", currencyStubHandler(nordicRates), http.StatusOK, ""},
+		{
+			name: "result error",
+			currency: func(w http.ResponseWriter, r *http.Request) {
+				_, _ = w.Write([]byte(`{"result":"error","error-type":"quota-reached"}`))
+			},
+			wantStatus: http.StatusBadGateway,
+			wantError:  "currency service returned result != success",
+		},
+		{"missing target", currencyStubHandler(map[string]map[string]float64{"NOK": {"EUR": 0.085}}), http.StatusNotFound, "no rate from NOK to SEK"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			useUpstreams(t, nil, newUpstreamStub(t, tt.currency), nil)
+
+			rec := httptest.NewRecorder()
+			BasketHandler(rec, httptest.NewRequest(http.MethodPost, "/countryinfo/v1/basket", strings.NewReader(body)))
+			if rec.Code != tt.wantStatus {
+				t.Fatalf("status %d, want %d; body %s", rec.Code, tt.wantStatus, rec.Body)
+			}
+			if tt.wantError != "" && !strings.Contains(rec.Body.String(), tt.wantError) {
+				t.Errorf("body %s, want error %q", rec.Body, tt.wantError)
+			}
+		})
+	}
+}
@@ -0,0 +1,117 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+	"sync"
+)
+
+/* -------------------- INFO batch endpoint -------------------- */
+
+const maxBatchCodes = 20
+
+// batchInfoEntry is one result of a batch request: the info fields on
+// success, or an error for that code only.
+type batchInfoEntry struct {
+	Code string `json:"code"`
+	*infoResponse
+	Error string `json:"error,omitempty"`
+}
+
+// keyedInfoEntry is a batchInfoEntry without the code, which is its key in
+// ?keyed=true mode.
+type keyedInfoEntry struct {
+	*infoResponse
+	Error string `json:"error,omitempty"`
+}
+
+// InfoBatchHandler serves /countryinfo/v1/info?codes=no,se,dk. Without
+// ?codes the bare path redirects to /countryinfo/v1/info/ as before.
+func InfoBatchHandler(w http.ResponseWriter, r *http.Request) {
+	if !r.URL.Query().Has("codes") {
+		redirectToPath(w, r, "/countryinfo/v1/info/")
+		return
+	}
+	if r.Method != http.MethodGet {
+		writeJSONError(w, http.StatusMethodNotAllowed, "method not allowed")
+		return
+	}
+	if !checkQueryParams(w, r, "codes", "keyed") {
+		return
+	}
+
+	var codes []string
+	for _, part := range strings.Split(r.URL.Query().Get("codes"), ",") {
+		if part = strings.TrimSpace(part); part != "" {
+			codes = append(codes, part)
+		}
+	}
+	if len(codes) == 0 {
+		writeJSONError(w, http.StatusBadRequest, "codes must be a comma-separated list of 2- or 3-letter country codes, e.g. ?codes=no,se")
+		return
+	}
+	if len(codes) > maxBatchCodes {
+		writeJSONError(w, http.StatusBadRequest, fmt.Sprintf("at most %d codes per batch request", maxBatchCodes))
+		return
+	}
+
+	r = withAlphaMemo(r) // a repeated code (no,NO) is looked up once
+	entries := fetchInfoBatch(r, codes)
+	if r.URL.Query().Get("keyed") != "true" {
+		writeJSON(w, http.StatusOK, entries)
+		return
+	}
+
+	// Invalid and failed codes stay in as error entries under their own key
+	keyed := make(map[string]keyedInfoEntry, len(entries))
+	for _, e := range entries {
+		keyed[e.Code] = keyedInfoEntry{infoResponse: e.infoResponse, Error: e.Error}
+	}
+	writeJSON(w, http.StatusOK, keyed)
+}
+
+// fetchInfoBatch resolves every code concurrently, in input order. Lookups go
+// through fetchCountryAlpha, so they share the cache and in-flight requests
+// with single info requests.
+func fetchInfoBatch(r *http.Request, codes []string) []batchInfoEntry {
+	out := make([]batchInfoEntry, len(codes))
+	sem := make(chan struct{}, LoadConfig().NeighbourWorkers)
+	var wg sync.WaitGroup
+
+	for i, raw := range codes {
+		code := normalizeISO2(raw)
+		out[i].Code = code
+		// Same codes as /info/{code}: alpha-2 or alpha-3
+		if !validISOAlpha(code) {
+			out[i].Code = raw
+			out[i].Error = "invalid code, expected 2 or 3 letters (ISO 3166-1 alpha-2 or alpha-3)"
+			continue
+		}
+
+		wg.Add(1)
+		sem <- struct{}{}
+		go func(i int, code string) {
+			defer wg.Done()
+			defer func() { <-sem }()
+
+			c, st, err := fetchCountryAlpha(r.Context(), code)
+			switch {
+			case errors.Is(err, errAmbiguousAlpha):
+				out[i].Error = err.Error()
+			case err != nil:
+				out[i].Error = upstreamErrorMessage(err, "failed to call countries service")
+			case st == http.StatusNotFound || (st == http.StatusOK && c == nil):
+				out[i].Error = "country not found"
+			case st != http.StatusOK:
+				out[i].Error = "countries service returned non-200"
+			default:
+				info := toInfoResponse(c)
+				out[i].infoResponse = &info
+			}
+		}(i, code)
+	}
+	wg.Wait()
+	return out
+}
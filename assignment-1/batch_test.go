@@ -0,0 +1,84 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"reflect"
+	"slices"
+	"testing"
+)
+
+// The batch endpoint answers every requested code, as an array in input
+// order by default or, with ?keyed=true, as an object keyed by code. Failed
+// and invalid codes are error entries, never dropped.
+func TestInfoBatch(t *testing.T) {
+	nordicUpstreams(t, nil)
+
+	type entry struct {
+		Code  string `json:"code"`
+		Name  string `json:"name"`
+		Error string `json:"error"`
+	}
+	want := []entry{
+		{Code: "no", Name: "Norway"},
+		{Code: "swe", Name: "Sweden"},
+		{Code: "zz", Error: "country not found"},
+		{Code: "n0rway", Error: "invalid code, expected 2 or 3 letters (ISO 3166-1 alpha-2 or alpha-3)"},
+	}
+	const codes = "no,SWE,zz,n0rway"
+
+	t.Run("array", func(t *testing.T) {
+		var got []entry
+		getJSON(t, InfoBatchHandler, "/countryinfo/v1/info?codes="+codes, http.StatusOK, &got)
+		if !reflect.DeepEqual(got, want) {
+			t.Errorf("got %+v, want %+v", got, want)
+		}
+	})
+
+	t.Run("keyed", func(t *testing.T) {
+		var raw map[string]json.RawMessage
+		getJSON(t, InfoBatchHandler, "/countryinfo/v1/info?keyed=true&codes="+codes, http.StatusOK, &raw)
+
+		keys := make([]string, 0, len(raw))
+		for k := range raw {
+			keys = append(keys, k)
+		}
+		slices.Sort(keys)
+		if wantKeys := []string{"n0rway", "no", "swe", "zz"}; !slices.Equal(keys, wantKeys) {
+			t.Fatalf("keys %v, want %v", keys, wantKeys)
+		}
+		for _, w := range want {
+			var fields map[string]any
+			if err := json.Unmarshal(raw[w.Code], &fields); err != nil {
+				t.Fatalf("%s: %v", w.Code, err)
+			}
+			if _, ok := fields["code"]; ok {
+				t.Errorf("%s: entry repeats its key as code", w.Code)
+			}
+			if fields["name"] != nil && fields["name"] != w.Name || fields["name"] == nil && w.Name != "" {
+				t.Errorf("%s: name %v, want %q", w.Code, fields["name"], w.Name)
+			}
+			if got, _ := fields["error"].(string); got != w.Error {
+				t.Errorf("%s: error %q, want %q", w.Code, got, w.Error)
+			}
+		}
+	})
+}
+
+// Malformed batch requests are rejected as a whole.
+func TestInfoBatchRejects(t *testing.T) {
+	nordicUpstreams(t, nil)
+	tests := []struct {
+		target     string
+		wantStatus int
+	}{
+		{"/countryinfo/v1/info?codes=", http.StatusBadRequest},
+		{"/countryinfo/v1/info?codes=,%20,", http.StatusBadRequest},
+		{"/countryinfo/v1/info?codes=a,b,c,d,e,f,g,h,i,j,k,l,m,n,o,p,q,r,s,t,u", http.StatusBadRequest},
+	}
+	for _, tt := range tests {
+		t.Run(tt.target, func(t *testing.T) {
+			getJSON(t, InfoBatchHandler, tt.target, tt.wantStatus, nil)
+		})
+	}
+}
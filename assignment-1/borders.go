@@ -0,0 +1,176 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"strings"
+	"sync"
+)
+
+/* -------------------- INFO border expansion -------------------- */
+
+// Borders-of-borders grow quickly, so depth is capped and every expansion
+// shares one budget of upstream lookups.
+const (
+	maxBorderDepth   = 2
+	maxBorderFetches = 60
+
+	defaultNeighbourWorkers = 5
+	maxNeighbourWorkers     = 20
+)
+
+var errBorderFetchCap = fmt.Errorf("border expansion needs more than %d country lookups; use a smaller depth", maxBorderFetches)
+
+// expandBorders walks the border graph breadth-first from root, up to depth
+// levels. Each country is fetched and expanded at most once, so a country
+// already seen closer to the root is not repeated further down.
+func expandBorders(ctx context.Context, root *countriesCountry, depth int) ([]infoResponse, error) {
+	if depth == 0 {
+		return nil, nil
+	}
+
+	rootCode := strings.ToUpper(root.CCA3)
+	seen := map[string]bool{rootCode: true}
+	children := make(map[string][]string) // parent cca3 -> newly discovered border cca3s
+	fetched := make(map[string]*countriesCountry)
+	fetchCount := 0
+
+	frontier := []*countriesCountry{root}
+	for level := 0; level < depth && len(frontier) > 0; level++ {
+		var next []string
+		for _, parent := range frontier {
+			parentCode := strings.ToUpper(parent.CCA3)
+			for _, b := range parent.Borders {
+				b = strings.ToUpper(strings.TrimSpace(b))
+				if b == "" || seen[b] {
+					continue
+				}
+				seen[b] = true
+				children[parentCode] = append(children[parentCode], b)
+				next = append(next, b)
+			}
+		}
+
+		fetchCount += len(next)
+		if fetchCount > maxBorderFetches {
+			return nil, errBorderFetchCap
+		}
+
+		countries, err := fetchCountriesConcurrently(ctx, next)
+		if err != nil {
+			return nil, err
+		}
+
+		frontier = frontier[:0]
+		for _, code := range next {
+			if c, ok := countries[code]; ok { // unknown codes were skipped
+				fetched[code] = c
+				frontier = append(frontier, c)
+			}
+		}
+	}
+
+	return buildBorderTree(rootCode, children, fetched), nil
+}
+
+func buildBorderTree(code string, children map[string][]string, fetched map[string]*countriesCountry) []infoResponse {
+	kids := children[code]
+	if len(kids) == 0 {
+		return nil
+	}
+	out := make([]infoResponse, 0, len(kids))
+	for _, k := range kids {
+		if fetched[k] == nil {
+			continue
+		}
+		info := toInfoResponse(fetched[k])
+		info.Neighbours = buildBorderTree(k, children, fetched)
+		out = append(out, info)
+	}
+	return out
+}
+
+// fetchNeighbours resolves c's border codes (cca3) to countries, in border
+// order, leaving out codes the upstream does not know. A country without
+// borders yields an empty slice.
+func fetchNeighbours(ctx context.Context, c *countriesCountry) ([]*countriesCountry, error) {
+	var borders []string
+	for _, cca3 := range c.Borders {
+		if cca3 = strings.TrimSpace(cca3); cca3 != "" {
+			borders = append(borders, cca3)
+		}
+	}
+	byCode, err := fetchCountriesConcurrently(ctx, borders) // alpha accepts cca3 too
+	if err != nil {
+		return nil, err
+	}
+	out := make([]*countriesCountry, 0, len(borders))
+	for _, cca3 := range borders {
+		if nc, ok := byCode[cca3]; ok {
+			out = append(out, nc)
+		}
+	}
+	return out, nil
+}
+
+// fetchCountriesConcurrently looks up codes with at most NEIGHBOUR_WORKERS
+// requests in flight. The first failure cancels the lookups still running or
+// waiting and is returned. A code the upstream answers with 404 is logged
+// and left out of the map: incomplete border data should not fail the
+// whole request.
+func fetchCountriesConcurrently(ctx context.Context, codes []string) (map[string]*countriesCountry, error) {
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	var (
+		mu       sync.Mutex
+		wg       sync.WaitGroup
+		firstErr error
+		out      = make(map[string]*countriesCountry, len(codes))
+		sem      = make(chan struct{}, LoadConfig().NeighbourWorkers)
+	)
+
+	for _, code := range codes {
+		sem <- struct{}{}
+		if ctx.Err() != nil {
+			<-sem
+			break
+		}
+		wg.Add(1)
+		go func(code string) {
+			defer wg.Done()
+			defer func() { <-sem }()
+
+			c, st, err := fetchCountryAlpha(ctx, code)
+			switch {
+			case err != nil:
+				err = upstreamFailure(err, "failed to call countries service for neighbours")
+			case st == http.StatusNotFound:
+				log.Printf("WARN border code %s not found upstream, skipping it", code)
+				return
+			case st != http.StatusOK || c == nil:
+				err = errors.New("countries service failed neighbour lookup")
+			}
+
+			mu.Lock()
+			defer mu.Unlock()
+			if err != nil {
+				if firstErr == nil {
+					firstErr = err
+					cancel()
+				}
+				return
+			}
+			out[code] = c
+		}(code)
+	}
+	wg.Wait()
+
+	if firstErr != nil {
+		return nil, firstErr
+	}
+	return out, nil
+}
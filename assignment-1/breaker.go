@@ -0,0 +1,125 @@
+package main
+
+import (
+	"errors"
+	"net/http"
+	"sync"
+	"time"
+)
+
+/* -------------------- CURRENCY circuit breaker -------------------- */
+
+const (
+	defaultBreakerFailures = 5
+	defaultBreakerWindow   = time.Minute
+	defaultBreakerCooldown = 30 * time.Second
+)
+
+const (
+	breakerClosed   = "closed"
+	breakerOpen     = "open"
+	breakerHalfOpen = "half-open"
+)
+
+// errCircuitOpen is returned instead of calling the currency service while
+// the breaker is open; handlers answer it with 503.
+var errCircuitOpen = errors.New("currency service unavailable (circuit open)")
+
+// circuitBreaker opens after Config.BreakerFailures consecutive failures,
+// each within Config.BreakerWindow of the previous one, and then fails fast
+// for Config.BreakerCooldown. After that a single trial call is let through
+// (half-open): success closes the breaker, failure opens it again.
+type circuitBreaker struct {
+	mu          sync.Mutex
+	state       string
+	failures    int
+	lastFailure time.Time
+	openedAt    time.Time
+	trial       bool // a half-open trial call is in flight
+}
+
+var currencyBreaker = &circuitBreaker{state: breakerClosed}
+
+// allow reports whether a call may go out now.
+func (b *circuitBreaker) allow() bool {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	switch b.state {
+	case breakerOpen:
+		if time.Since(b.openedAt) < LoadConfig().BreakerCooldown {
+			return false
+		}
+		b.state = breakerHalfOpen
+		b.trial = true
+		return true
+	case breakerHalfOpen:
+		if b.trial {
+			return false
+		}
+		b.trial = true
+		return true
+	}
+	return true
+}
+
+// record reports the outcome of a call that allow let through.
+func (b *circuitBreaker) record(ok bool) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	cfg := LoadConfig()
+	now := time.Now()
+	if ok {
+		b.state, b.failures, b.trial = breakerClosed, 0, false
+		return
+	}
+	if b.state == breakerHalfOpen {
+		b.state, b.openedAt, b.trial = breakerOpen, now, false
+		statsd.incr("breaker.currency.open")
+		return
+	}
+	if now.Sub(b.lastFailure) > cfg.BreakerWindow {
+		b.failures = 0
+	}
+	b.failures++
+	b.lastFailure = now
+	if b.failures >= cfg.BreakerFailures {
+		b.state, b.openedAt = breakerOpen, now
+		statsd.incr("breaker.currency.open")
+	}
+}
+
+// release ends a call that allow let through without counting it either way,
+// e.g. when the client went away. A half-open breaker lets the next call be
+// the trial instead.
+func (b *circuitBreaker) release() {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if b.state == breakerHalfOpen {
+		b.trial = false
+	}
+}
+
+// current returns the state for reporting; an open breaker whose cooldown
+// has passed shows as half-open, since the next call will be a trial.
+func (b *circuitBreaker) current() string {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if b.state == breakerOpen && time.Since(b.openedAt) >= LoadConfig().BreakerCooldown {
+		return breakerHalfOpen
+	}
+	return b.state
+}
+
+// writeRatesError answers a failed rate lookup: 503 while the breaker is
+// open, 502 (see writeUpstreamError) otherwise.
+func writeRatesError(w http.ResponseWriter, err error) {
+	if errors.Is(err, errCircuitOpen) {
+		writeJSONError(w, http.StatusServiceUnavailable, err.Error())
+		return
+	}
+	writeUpstreamError(w, err, "failed to call currency service")
+}
@@ -0,0 +1,478 @@
+package main
+
+import (
+	"container/list"
+	"context"
+	"errors"
+	"log"
+	"net/http"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+/* -------------------- TTL cache -------------------- */
+
+// ttlCache is a small concurrency-safe cache with a fixed TTL per entry and a
+// maximum number of entries. When full, the least recently stored entry is
+// evicted; keys are kept in store order, so that costs O(1).
+// Values are handed out as-is, so they must be treated as immutable: an
+// update stores a new value instead of changing the old one in place.
+type ttlCache[V any] struct {
+	mu      sync.RWMutex // readers share the lock; set/renew/evict take it exclusively
+	ttl     time.Duration
+	max     int
+	entries map[string]cacheEntry[V]
+	order   *list.List // keys, least recently stored at the front
+}
+
+type cacheEntry[V any] struct {
+	value   V
+	expires time.Time
+	elem    *list.Element // the key's place in order
+}
+
+func newTTLCache[V any](ttl time.Duration, max int) *ttlCache[V] {
+	return &ttlCache[V]{
+		ttl:     ttl,
+		max:     max,
+		entries: make(map[string]cacheEntry[V]),
+		order:   list.New(),
+	}
+}
+
+// get returns the cached value for key if present and not expired.
+func (c *ttlCache[V]) get(key string) (V, bool) {
+	v, fresh, ok := c.lookup(key)
+	return v, ok && fresh
+}
+
+// lookup returns the cached value even when expired; fresh reports whether
+// it is still within its TTL.
+func (c *ttlCache[V]) lookup(key string) (v V, fresh bool, ok bool) {
+	v, expires, ok := c.peek(key)
+	return v, ok && time.Now().Before(expires), ok
+}
+
+// peek returns the cached value and when it expires (or expired).
+func (c *ttlCache[V]) peek(key string) (v V, expires time.Time, ok bool) {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+
+	e, ok := c.entries[key]
+	if !ok {
+		return v, time.Time{}, false
+	}
+	return e.value, e.expires, true
+}
+
+// renew restarts the TTL of an existing entry without replacing its value.
+func (c *ttlCache[V]) renew(key string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if e, ok := c.entries[key]; ok {
+		e.expires = time.Now().Add(c.ttl)
+		c.entries[key] = e
+	}
+}
+
+// configure changes TTL and size limit, e.g. from Config at startup.
+// Existing entries keep their expiry.
+func (c *ttlCache[V]) configure(ttl time.Duration, max int) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.ttl, c.max = ttl, max
+	for c.max > 0 && len(c.entries) > c.max {
+		c.evictOldestLocked()
+	}
+}
+
+// len returns the number of entries, expired ones included.
+func (c *ttlCache[V]) len() int {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	return len(c.entries)
+}
+
+func (c *ttlCache[V]) set(key string, v V) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	e, exists := c.entries[key]
+	if exists {
+		c.order.MoveToBack(e.elem)
+	} else {
+		if c.max > 0 && len(c.entries) >= c.max {
+			c.evictOldestLocked()
+		}
+		e.elem = c.order.PushBack(key)
+	}
+	e.value, e.expires = v, time.Now().Add(c.ttl)
+	c.entries[key] = e
+}
+
+func (c *ttlCache[V]) evictOldestLocked() {
+	oldest := c.order.Front()
+	if oldest == nil {
+		return
+	}
+	c.order.Remove(oldest)
+	delete(c.entries, oldest.Value.(string))
+}
+
+/* -------------------- COUNTRY cache -------------------- */
+
+// cachedCountry keeps the upstream ETag next to the data, so an expired entry
+// can be revalidated with If-None-Match instead of downloaded again.
+type cachedCountry struct {
+	country *countriesCountry
+	etag    string
+}
+
+const (
+	defaultCountryCacheTTL = 10 * time.Minute
+	defaultCountryCacheMax = 512
+)
+
+// Configured from COUNTRY_CACHE_TTL and COUNTRY_CACHE_MAX by applyConfig
+var countryCache = newTTLCache[cachedCountry](defaultCountryCacheTTL, defaultCountryCacheMax)
+
+// Lookup outcomes since startup, reported on the diag endpoint
+var countryCacheHits, countryCacheStale, countryCacheMisses, countryCacheStaleOnError atomic.Int64
+
+const (
+	// How long past its TTL an entry may still be served while it is
+	// refreshed in the background (stale-while-revalidate)
+	countryStaleWindow = time.Hour
+	// Background refreshes outlive the request that triggered them, so they
+	// get their own deadline instead of the request's context
+	countryRefreshTimeout = 10 * time.Second
+)
+
+// fetchCountryAlpha returns a country by alpha code, served from cache while
+// fresh. A recently expired entry is still returned at once and refreshed in
+// the background; older ones are refreshed before returning. Refreshes with an
+// ETag are conditional, and a 304 renews the TTL without decoding anything.
+// Within a request that carries an alphaMemo, each code is looked up once.
+func fetchCountryAlpha(ctx context.Context, code string) (*countriesCountry, int, error) {
+	key := strings.ToLower(strings.TrimSpace(code))
+	memo, ok := ctx.Value(alphaMemoKey).(*alphaMemo)
+	if !ok {
+		c, st, err := lookupCountryAlpha(ctx, key, code)
+		recordFetch("country", st, err)
+		return c, st, err
+	}
+
+	memo.mu.Lock()
+	res, ok := memo.results[key]
+	if !ok {
+		res = &alphaMemoResult{}
+		memo.results[key] = res
+	}
+	memo.mu.Unlock()
+
+	res.once.Do(func() {
+		res.country, res.status, res.err = lookupCountryAlpha(ctx, key, code)
+		recordFetch("country", res.status, res.err)
+	})
+	return res.country, res.status, res.err
+}
+
+// alphaMemo is a request-scoped record of alpha lookups, so a code that comes
+// up several times while serving one request (e.g. duplicate borders) is
+// looked up once. Unlike countryCache it also remembers failures and 404s,
+// and it is dropped with the request.
+type alphaMemo struct {
+	mu      sync.Mutex
+	results map[string]*alphaMemoResult
+}
+
+type alphaMemoResult struct {
+	once    sync.Once
+	country *countriesCountry
+	status  int
+	err     error
+}
+
+// withAlphaMemo attaches a fresh alphaMemo to the request's context.
+func withAlphaMemo(r *http.Request) *http.Request {
+	memo := &alphaMemo{results: make(map[string]*alphaMemoResult)}
+	return r.WithContext(context.WithValue(r.Context(), alphaMemoKey, memo))
+}
+
+// lookupCountryAlpha is fetchCountryAlpha without the request memo.
+func lookupCountryAlpha(ctx context.Context, key, code string) (*countriesCountry, int, error) {
+	entry, expires, ok := countryCache.peek(key)
+	now := time.Now()
+	if ok && now.Before(expires) {
+		countryCacheHits.Add(1)
+		statsd.incr("cache.country.hit")
+		observeFetch(ctx, entry.country.fetched)
+		return entry.country, http.StatusOK, nil
+	}
+	if ok && now.Before(expires.Add(countryStaleWindow)) {
+		countryCacheStale.Add(1)
+		statsd.incr("cache.country.stale")
+		go refreshCountry(key, code, entry)
+		observeFetch(ctx, entry.country.fetched)
+		return entry.country, http.StatusOK, nil
+	}
+	countryCacheMisses.Add(1)
+	statsd.incr("cache.country.miss")
+
+	res, err := revalidateCountry(ctx, key, code, entry, ok)
+	upstreamFailed := (err != nil && !errors.Is(err, errAmbiguousAlpha)) || res.status >= http.StatusInternalServerError
+	if ok && upstreamFailed && serveStale(ctx) {
+		countryCacheStaleOnError.Add(1)
+		statsd.incr("cache.country.stale_on_error")
+		log.Printf("countries service failed for %q; serving cached entry that expired at %s", code, expires.Format(time.RFC3339))
+		observeFetch(ctx, entry.country.fetched)
+		return entry.country, http.StatusOK, nil
+	}
+	if err != nil {
+		return nil, 0, err
+	}
+	if res.country != nil {
+		observeFetch(ctx, res.country.fetched)
+	}
+	return res.country, res.status, nil
+}
+
+// withStaleMark lets country lookups made with the returned request fall back
+// to an expired cache entry when the upstream fails (SERVE_STALE_ON_ERROR).
+// The mark is set when that happened, so the handler can tell the client;
+// lookups without it never get data older than countryStaleWindow.
+func withStaleMark(r *http.Request) (*http.Request, *atomic.Bool) {
+	mark := new(atomic.Bool)
+	return r.WithContext(context.WithValue(r.Context(), staleServedKey, mark)), mark
+}
+
+// serveStale reports whether ctx accepts stale data and, if so, marks it.
+func serveStale(ctx context.Context) bool {
+	mark, ok := ctx.Value(staleServedKey).(*atomic.Bool)
+	if !ok || !LoadConfig().ServeStaleOnError {
+		return false
+	}
+	mark.Store(true)
+	return true
+}
+
+const staleWarning = "countries service is unavailable; serving cached data that may be out of date"
+
+// markStale sets X-Cache: stale and returns the body warning when a lookup
+// behind mark was served stale, or "" otherwise.
+func markStale(w http.ResponseWriter, mark *atomic.Bool) string {
+	if !mark.Load() {
+		return ""
+	}
+	w.Header().Set("X-Cache", "stale")
+	return staleWarning
+}
+
+// refreshCountry revalidates a stale entry in the background. It must not use
+// the triggering request's context: that is cancelled as soon as the response
+// is written (or the client goes away), which would abort the refresh.
+func refreshCountry(key, code string, entry cachedCountry) {
+	ctx, cancel := context.WithTimeout(context.Background(), countryRefreshTimeout)
+	defer cancel()
+
+	if _, err := revalidateCountry(ctx, key, code, entry, true); err != nil {
+		log.Printf("background refresh of country %q failed: %v", code, err)
+	}
+}
+
+// revalidateCountry fetches code from the upstream and updates the cache.
+// Concurrent calls for the same code (single, batch, neighbour lookups and
+// background refreshes alike) share one upstream request.
+func revalidateCountry(ctx context.Context, key, code string, entry cachedCountry, cached bool) (countryFetch, error) {
+	return countryFlight.do(ctx, key, upstreamCallBudget(LoadConfig()), func(ctx context.Context) (countryFetch, error) {
+		c, etag, st, err := fetchCountryAlphaUpstream(ctx, code, entry.etag)
+		if err != nil {
+			return countryFetch{}, err
+		}
+		if st == http.StatusNotModified && cached {
+			countryCache.renew(key)
+			return countryFetch{country: entry.country, status: http.StatusOK}, nil
+		}
+		if st != http.StatusOK {
+			return countryFetch{status: st}, nil
+		}
+		countryCache.set(key, cachedCountry{country: c, etag: etag})
+		return countryFetch{country: c, status: http.StatusOK}, nil
+	})
+}
+
+type countryFetch struct {
+	country *countriesCountry
+	status  int
+}
+
+var countryFlight = newFlightGroup[countryFetch]()
+
+/* -------------------- Request coalescing -------------------- */
+
+// flightGroup runs at most one call per key at a time; callers arriving while
+// it runs wait for and share its result.
+type flightGroup[V any] struct {
+	mu    sync.Mutex
+	calls map[string]*flightCall[V]
+}
+
+type flightCall[V any] struct {
+	done chan struct{} // closed once val and err are set
+	val  V
+	err  error
+}
+
+func newFlightGroup[V any]() *flightGroup[V] {
+	return &flightGroup[V]{calls: make(map[string]*flightCall[V])}
+}
+
+// do runs fn for key unless a call is already running, and returns the
+// shared result. fn gets ctx's values but not its cancellation, and its own
+// timeout instead: it serves every waiting caller, so the one that started
+// it going away must not fail the rest. Each caller still stops waiting, with
+// its own ctx.Err(), when its context ends.
+func (g *flightGroup[V]) do(ctx context.Context, key string, timeout time.Duration, fn func(context.Context) (V, error)) (V, error) {
+	g.mu.Lock()
+	call, ok := g.calls[key]
+	if !ok {
+		call = &flightCall[V]{done: make(chan struct{})}
+		g.calls[key] = call
+		go g.run(context.WithoutCancel(ctx), key, call, timeout, fn)
+	}
+	g.mu.Unlock()
+
+	select {
+	case <-call.done:
+		return call.val, call.err
+	case <-ctx.Done():
+		var zero V
+		return zero, ctx.Err()
+	}
+}
+
+func (g *flightGroup[V]) run(ctx context.Context, key string, call *flightCall[V], timeout time.Duration, fn func(context.Context) (V, error)) {
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+
+	call.val, call.err = fn(ctx)
+
+	g.mu.Lock()
+	delete(g.calls, key)
+	g.mu.Unlock()
+	close(call.done)
+}
+
+/* -------------------- RATES cache -------------------- */
+
+// Rates are cached both as whole tables per base and as single base/target
+// pairs. Pairs are filled from every fetched table with the same TTL, so the
+// two views never disagree for longer than one TTL.
+const (
+	defaultRatesCacheTTL = time.Minute
+	defaultRatesCacheMax = 64 // tables; current and historical ones count alike
+	ratePairCacheMax     = 4096
+)
+
+// Configured from RATES_CACHE_TTL and RATES_CACHE_MAX by applyConfig
+var (
+	ratesCache    = newTTLCache[*upstreamCurrencyResponse](defaultRatesCacheTTL, defaultRatesCacheMax)
+	ratePairCache = newTTLCache[float64](defaultRatesCacheTTL, ratePairCacheMax)
+)
+
+// Lookup outcomes since startup, reported on the diag endpoint
+var ratesCacheHits, ratesCacheMisses atomic.Int64
+
+func ratePairKey(base, target string) string {
+	return base + "/" + target
+}
+
+// fetchRates returns the current rate table for base, served from cache while
+// fresh. The returned table is shared and must not be modified; a refresh
+// replaces the cached pointer with a freshly decoded table, so a reader
+// holding the old one keeps a complete, consistent snapshot.
+func fetchRates(ctx context.Context, base string) (*upstreamCurrencyResponse, int, error) {
+	return fetchRatesOn(ctx, base, "")
+}
+
+// fetchRatesOn is fetchRates for a given date (YYYY-MM-DD), "" meaning current.
+// Historical tables are cached under their own key; only current tables
+// feed the pair cache.
+func fetchRatesOn(ctx context.Context, base, date string) (*upstreamCurrencyResponse, int, error) {
+	table, st, err := lookupRates(ctx, strings.ToUpper(base), date)
+	recordFetch("rates", st, err)
+	return table, st, err
+}
+
+// lookupRates is fetchRatesOn without the fetch metrics; base is uppercase.
+func lookupRates(ctx context.Context, base, date string) (*upstreamCurrencyResponse, int, error) {
+	key := base
+	if date != "" {
+		key = base + "@" + date
+	}
+	if table, ok := ratesCache.get(key); ok {
+		ratesCacheHits.Add(1)
+		statsd.incr("cache.rates.hit")
+		observeFetch(ctx, table.fetched)
+		return table, http.StatusOK, nil
+	}
+	ratesCacheMisses.Add(1)
+	statsd.incr("cache.rates.miss")
+
+	if !currencyBreaker.allow() {
+		statsd.incr("breaker.currency.rejected")
+		return nil, 0, errCircuitOpen
+	}
+	table, st, err := fetchRatesUpstream(ctx, base, date)
+	if errors.Is(err, context.Canceled) || ctx.Err() != nil {
+		// The client went away or the request ran out of time; that says
+		// nothing about the currency service
+		currencyBreaker.release()
+	} else {
+		// A 4xx (e.g. an unsupported date) is an answer, not an outage
+		currencyBreaker.record(err == nil && st < http.StatusInternalServerError)
+	}
+	if err != nil || st != http.StatusOK || table == nil {
+		return table, st, err
+	}
+	// Only cache tables the handlers would accept
+	if table.Result == "" || table.Result == "success" {
+		ratesCache.set(key, table)
+		if date == "" {
+			for target, v := range table.Rates {
+				ratePairCache.set(ratePairKey(base, target), v)
+			}
+			rateSnapshots.record(base, table)
+		}
+	}
+	observeFetch(ctx, table.fetched)
+	return table, http.StatusOK, nil
+}
+
+// errRatesNotSuccess is returned by lookupRate for a table the currency
+// service marks as failed, so it is not mistaken for a missing target.
+var errRatesNotSuccess = errors.New("currency service returned result != success")
+
+// lookupRate returns a single base -> target rate, from the pair cache when
+// possible and otherwise via the (cached) full table. found is false when the
+// table has no such target.
+func lookupRate(ctx context.Context, base, target string) (rate float64, found bool, status int, err error) {
+	base, target = strings.ToUpper(base), strings.ToUpper(target)
+	if v, ok := ratePairCache.get(ratePairKey(base, target)); ok {
+		return v, true, http.StatusOK, nil
+	}
+
+	table, st, err := fetchRates(ctx, base)
+	if err != nil || st != http.StatusOK || table == nil {
+		return 0, false, st, err
+	}
+	if table.Result != "" && table.Result != "success" {
+		return 0, false, st, errRatesNotSuccess
+	}
+	v, ok := table.Rates[target]
+	return v, ok, http.StatusOK, nil
+}
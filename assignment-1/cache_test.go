@@ -0,0 +1,433 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// Single and batch info requests for overlapping codes share one upstream
+// lookup per code.
+func TestCountryLookupsCoalesceAcrossRequests(t *testing.T) {
+	handler := countriesStubHandler(t, nordicFixtures...)
+	countries := newUpstreamStub(t, func(w http.ResponseWriter, r *http.Request) {
+		time.Sleep(50 * time.Millisecond) // keep the first lookup in flight
+		handler(w, r)
+	})
+	useUpstreams(t, countries, nil, nil)
+
+	const n = 10
+	var wg sync.WaitGroup
+	codes := make(chan int, 2*n)
+	for range n {
+		wg.Add(2)
+		go func() {
+			defer wg.Done()
+			codes <- serve(http.HandlerFunc(InfoHandler), http.MethodGet, "/countryinfo/v1/info/no", nil).Code
+		}()
+		go func() {
+			defer wg.Done()
+			codes <- serve(http.HandlerFunc(InfoBatchHandler), http.MethodGet, "/countryinfo/v1/info?codes=no,se", nil).Code
+		}()
+	}
+	wg.Wait()
+	close(codes)
+
+	for code := range codes {
+		if code != http.StatusOK {
+			t.Errorf("status %d, want 200", code)
+		}
+	}
+	for _, path := range []string{"/alpha/no", "/alpha/se"} {
+		if got := countries.hitCount(path); got != 1 {
+			t.Errorf("%s fetched %d times, want 1", path, got)
+		}
+	}
+}
+
+// The caller that started a shared lookup going away does not fail the
+// callers waiting on it.
+func TestCoalescedLookupSurvivesFirstCallerCancel(t *testing.T) {
+	handler := countriesStubHandler(t, nordicFixtures...)
+	started := make(chan struct{}, 1)
+	release := make(chan struct{})
+	countries := newUpstreamStub(t, func(w http.ResponseWriter, r *http.Request) {
+		select {
+		case started <- struct{}{}:
+		default:
+		}
+		<-release
+		handler(w, r)
+	})
+	useUpstreams(t, countries, nil, nil)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	firstErr := make(chan error, 1)
+	go func() {
+		_, _, err := fetchCountryAlpha(ctx, "no")
+		firstErr <- err
+	}()
+	<-started
+
+	type result struct {
+		c   *countriesCountry
+		st  int
+		err error
+	}
+	second := make(chan result, 1)
+	go func() {
+		c, st, err := fetchCountryAlpha(context.Background(), "no")
+		second <- result{c, st, err}
+	}()
+	time.Sleep(20 * time.Millisecond) // let the second caller join the flight
+
+	cancel()
+	if err := <-firstErr; !errors.Is(err, context.Canceled) {
+		t.Errorf("first caller: err %v, want context.Canceled", err)
+	}
+	close(release)
+
+	res := <-second
+	if res.err != nil || res.st != http.StatusOK || res.c == nil || res.c.Name.Common != "Norway" {
+		t.Fatalf("second caller: got %v, %d, %v; want Norway, 200, nil", res.c, res.st, res.err)
+	}
+	if got := countries.hitCount("/alpha/no"); got != 1 {
+		t.Errorf("/alpha/no fetched %d times, want 1", got)
+	}
+}
+
+// Concurrent get, set, renew and expiry keep the cache within its bounds
+// and its key order in step with its entries.
+func TestTTLCacheConcurrentGetSetExpire(t *testing.T) {
+	c := newTTLCache[int](time.Millisecond, 8)
+	keys := []string{"a", "b", "c", "d", "e", "f", "g", "h", "i", "j", "k", "l"}
+
+	var wg sync.WaitGroup
+	for w := range 8 {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := range 500 {
+				key := keys[(w+i)%len(keys)]
+				switch i % 5 {
+				case 0, 1:
+					c.set(key, i)
+				case 2:
+					c.get(key)
+				case 3:
+					c.lookup(key)
+				case 4:
+					c.renew(key)
+					c.len()
+				}
+			}
+		}()
+	}
+	wg.Wait()
+
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	if len(c.entries) > 8 {
+		t.Errorf("%d entries, want at most 8", len(c.entries))
+	}
+	if len(c.entries) != c.order.Len() {
+		t.Errorf("%d entries but %d keys in order", len(c.entries), c.order.Len())
+	}
+	for el := c.order.Front(); el != nil; el = el.Next() {
+		if e, ok := c.entries[el.Value.(string)]; !ok || e.elem != el {
+			t.Errorf("key %q in order without a matching entry", el.Value)
+		}
+	}
+
+	time.Sleep(2 * time.Millisecond)
+	for _, key := range keys {
+		if _, ok := c.get(key); ok {
+			t.Errorf("get(%q) after expiry: still fresh", key)
+		}
+	}
+}
+
+// When the least recently stored entry is evicted, a key stored again moves
+// to the back of the line.
+func TestTTLCacheEvictsLeastRecentlyStored(t *testing.T) {
+	c := newTTLCache[int](time.Minute, 2)
+	c.set("a", 1)
+	c.set("b", 2)
+	c.set("a", 3) // a is now the most recently stored
+	c.set("c", 4)
+
+	if _, ok := c.get("b"); ok {
+		t.Error("b was not evicted")
+	}
+	if v, ok := c.get("a"); !ok || v != 3 {
+		t.Errorf("get(a) = %d, %t; want 3, true", v, ok)
+	}
+	if _, ok := c.get("c"); !ok {
+		t.Error("c missing")
+	}
+}
+
+// Readers racing with refreshes of the same base always get a complete
+// table: every rate in one table comes from the same upstream answer.
+func TestRatesReadersSeeCompleteTables(t *testing.T) {
+	var mu sync.Mutex
+	version := 0
+	currency := newUpstreamStub(t, func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		version++
+		v := float64(version)
+		mu.Unlock()
+		rates := map[string]map[string]float64{"NOK": {"SEK": v, "EUR": v, "USD": v, "RUB": v}}
+		currencyStubHandler(rates)(w, r)
+	})
+	useUpstreams(t, nil, currency, func(cfg *Config) {
+		cfg.RatesCacheTTL = time.Millisecond // refresh all the time
+	})
+
+	var wg sync.WaitGroup
+	for range 8 {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for range 50 {
+				table, st, err := fetchRates(context.Background(), "NOK")
+				if err != nil || st != http.StatusOK {
+					t.Errorf("fetchRates: %d, %v", st, err)
+					return
+				}
+				want := table.Rates["SEK"]
+				for ccy, v := range table.Rates {
+					if v != want {
+						t.Errorf("table mixes versions: %s=%v, SEK=%v", ccy, v, want)
+					}
+				}
+				if _, found, _, err := lookupRate(context.Background(), "NOK", "EUR"); err != nil || !found {
+					t.Errorf("lookupRate: %t, %v", found, err)
+				}
+			}
+		}()
+	}
+	wg.Wait()
+
+	if currency.hitCount("/NOK") < 2 {
+		t.Errorf("rates fetched %d times; the test needs refreshes", currency.hitCount("/NOK"))
+	}
+}
+
+// expireCountry makes a cached country expired by ago. Within
+// countryStaleWindow the next lookup is served stale and refreshed in the
+// background; past it the lookup revalidates before returning.
+func expireCountry(t *testing.T, key string, ago time.Duration) {
+	t.Helper()
+	countryCache.mu.Lock()
+	defer countryCache.mu.Unlock()
+	e, ok := countryCache.entries[key]
+	if !ok {
+		t.Fatalf("%q not cached", key)
+	}
+	e.expires = time.Now().Add(-ago)
+	countryCache.entries[key] = e
+}
+
+// An expired country is revalidated with the stored ETag; a 304 keeps the
+// cached data and renews it, a 200 replaces data and ETag.
+func TestCountryRevalidationWithETag(t *testing.T) {
+	tests := []struct {
+		name            string
+		etag            string // sent with every 200; "" for none
+		changeTo        string // the upstream's ETag after the first fetch
+		wantIfNoneMatch string
+		wantSame        bool // the cached *countriesCountry is kept
+		wantPopulation  int64
+	}{
+		{"unchanged", `"v1"`, `"v1"`, `"v1"`, true, 5379475},
+		{"changed", `"v1"`, `"v2"`, `"v1"`, false, 5400000},
+		{"no etag", "", "", "", false, 5400000},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var mu sync.Mutex
+			etag, body := tt.etag, "["+fixtureNorway+"]"
+			var ifNoneMatch []string
+			countries := newUpstreamStub(t, func(w http.ResponseWriter, r *http.Request) {
+				mu.Lock()
+				defer mu.Unlock()
+				ifNoneMatch = append(ifNoneMatch, r.Header.Get("If-None-Match"))
+				if etag != "" && r.Header.Get("If-None-Match") == etag {
+					w.WriteHeader(http.StatusNotModified)
+					return
+				}
+				if etag != "" {
+					w.Header().Set("ETag", etag)
+				}
+				_, _ = w.Write([]byte(body))
+			})
+			useUpstreams(t, countries, nil, nil)
+
+			first, st, err := fetchCountryAlpha(context.Background(), "no")
+			if err != nil || st != http.StatusOK {
+				t.Fatalf("first lookup: %d, %v", st, err)
+			}
+
+			mu.Lock()
+			etag = tt.changeTo
+			body = strings.Replace(body, "5379475", "5400000", 1)
+			mu.Unlock()
+			expireCountry(t, "no", countryStaleWindow+time.Second)
+
+			second, st, err := fetchCountryAlpha(context.Background(), "no")
+			if err != nil || st != http.StatusOK {
+				t.Fatalf("second lookup: %d, %v", st, err)
+			}
+			if got := ifNoneMatch[len(ifNoneMatch)-1]; got != tt.wantIfNoneMatch {
+				t.Errorf("If-None-Match %q, want %q", got, tt.wantIfNoneMatch)
+			}
+			if (second == first) != tt.wantSame {
+				t.Errorf("cached country kept: %t, want %t", second == first, tt.wantSame)
+			}
+			if second.Population != tt.wantPopulation {
+				t.Errorf("population %d, want %d", second.Population, tt.wantPopulation)
+			}
+
+			entry, expires, ok := countryCache.peek("no")
+			if !ok || !time.Now().Before(expires) {
+				t.Fatal("entry not fresh after revalidation")
+			}
+			if entry.etag != tt.changeTo {
+				t.Errorf("stored ETag %q, want %q", entry.etag, tt.changeTo)
+			}
+			if got := countries.hitCount("/alpha/no"); got != 2 {
+				t.Errorf("%d upstream requests, want 2", got)
+			}
+		})
+	}
+}
+
+// A background refresh started by a stale hit finishes even though the
+// request that triggered it was cancelled right after being answered.
+func TestStaleRefreshOutlivesRequest(t *testing.T) {
+	var mu sync.Mutex
+	body := "[" + fixtureNorway + "]"
+	refreshing := make(chan struct{}, 1)
+	release := make(chan struct{})
+	var fetches atomic.Int64
+	countries := newUpstreamStub(t, func(w http.ResponseWriter, r *http.Request) {
+		if fetches.Add(1) > 1 {
+			refreshing <- struct{}{}
+			<-release
+		}
+		mu.Lock()
+		defer mu.Unlock()
+		_, _ = w.Write([]byte(body))
+	})
+	useUpstreams(t, countries, nil, nil)
+
+	if _, st, err := fetchCountryAlpha(context.Background(), "no"); err != nil || st != http.StatusOK {
+		t.Fatalf("first lookup: %d, %v", st, err)
+	}
+	mu.Lock()
+	body = strings.Replace(body, "5379475", "5400000", 1)
+	mu.Unlock()
+	expireCountry(t, "no", time.Second)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	stale, st, err := fetchCountryAlpha(ctx, "no")
+	if err != nil || st != http.StatusOK || stale.Population != 5379475 {
+		t.Fatalf("stale lookup: %v, %d, %v; want the cached country", stale, st, err)
+	}
+	<-refreshing
+	cancel() // the client is gone while the refresh is in flight
+	close(release)
+
+	deadline := time.Now().Add(2 * time.Second)
+	for {
+		entry, expires, _ := countryCache.peek("no")
+		if time.Now().Before(expires) {
+			if entry.country.Population != 5400000 {
+				t.Errorf("refreshed population %d, want 5400000", entry.country.Population)
+			}
+			return
+		}
+		if time.Now().After(deadline) {
+			t.Fatal("refresh did not complete after the request was cancelled")
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+}
+
+// With SERVE_STALE_ON_ERROR, an upstream outage is answered from an expired
+// cache entry with X-Cache: stale and a warning; without the flag, for codes
+// never cached, or for a 4xx the upstream's answer stands.
+func TestServeStaleOnError(t *testing.T) {
+	tests := []struct {
+		name       string
+		handler    http.HandlerFunc
+		target     string // warmed up while the upstream is healthy, unless cold
+		cold       bool
+		failure    string // "503", "404" or "drop" (connection closed)
+		serveStale bool
+		wantStatus int
+	}{
+		{"info, 503", InfoHandler, "/countryinfo/v1/info/no", false, "503", true, http.StatusOK},
+		{"info, connection dropped", InfoHandler, "/countryinfo/v1/info/no", false, "drop", true, http.StatusOK},
+		{"exchange, 503", ExchangeHandler, "/countryinfo/v1/exchange/no", false, "503", true, http.StatusOK},
+		{"flag off", InfoHandler, "/countryinfo/v1/info/no", false, "503", false, http.StatusBadGateway},
+		{"not cached", InfoHandler, "/countryinfo/v1/info/no", true, "503", true, http.StatusBadGateway},
+		{"upstream 404", InfoHandler, "/countryinfo/v1/info/no", false, "404", true, http.StatusNotFound},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var failure atomic.Value
+			failure.Store("")
+			healthy := countriesStubHandler(t, nordicFixtures...)
+			countries := newUpstreamStub(t, func(w http.ResponseWriter, r *http.Request) {
+				switch failure.Load().(string) {
+				case "503":
+					w.WriteHeader(http.StatusServiceUnavailable)
+				case "404":
+					w.WriteHeader(http.StatusNotFound)
+				case "drop":
+					conn, _, _ := w.(http.Hijacker).Hijack()
+					conn.Close()
+				default:
+					healthy(w, r)
+				}
+			})
+			currency := newUpstreamStub(t, currencyStubHandler(nordicRates))
+			useUpstreams(t, countries, currency, func(cfg *Config) {
+				cfg.ServeStaleOnError = tt.serveStale
+			})
+
+			if !tt.cold {
+				getJSON(t, tt.handler, tt.target, http.StatusOK, nil)
+				countryCache.mu.RLock()
+				keys := make([]string, 0, len(countryCache.entries))
+				for key := range countryCache.entries {
+					keys = append(keys, key)
+				}
+				countryCache.mu.RUnlock()
+				for _, key := range keys {
+					expireCountry(t, key, countryStaleWindow+time.Second)
+				}
+			}
+			failure.Store(tt.failure)
+
+			rec := serve(tt.handler, http.MethodGet, tt.target, nil)
+			if rec.Code != tt.wantStatus {
+				t.Fatalf("status %d, want %d; body %s", rec.Code, tt.wantStatus, rec.Body)
+			}
+			stale := tt.wantStatus == http.StatusOK
+			if got := rec.Header().Get("X-Cache") == "stale"; got != stale {
+				t.Errorf("X-Cache %q, stale %t", rec.Header().Get("X-Cache"), stale)
+			}
+			if got := strings.Contains(rec.Body.String(), staleWarning); got != stale {
+				t.Errorf("body has the stale warning: %t, want %t", got, stale)
+			}
+		})
+	}
+}
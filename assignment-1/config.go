@@ -0,0 +1,297 @@
+package main
+
+import (
+	"log"
+	"net/http"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+/* -------------------- CONFIG -------------------- */
+
+// Config holds every environment-driven setting. Handlers read it through
+// LoadConfig, so they behave the same under main and when called directly.
+type Config struct {
+	Port                string
+	RequestIDHeader     string
+	AllFetchTimeout     time.Duration
+	DialTimeout         time.Duration
+	TLSHandshakeTimeout time.Duration
+	StatusProbeMethod   string
+	StrictParams        bool // reject unknown query parameters with 400
+	RequireBaseRates    bool // fail exchange when the base has an empty rate table
+	StatusProbeSamples  int  // concurrent probes per upstream in status
+	StatsDAddr          string
+	LenientCodes        bool // strip non-letters from country codes before validation
+	StatusWebhookURL    string
+	StatusPollInterval  time.Duration
+	SlowRequest         time.Duration // log requests slower than this
+	CurrencyPick        string        // how the base currency is chosen: sorted or first-listed
+	ResponseCacheTTL    time.Duration // 0 disables the response cache
+	ResponseCacheMax    int
+	EnableInfo          bool // serve the info endpoints
+	EnableExchange      bool // serve the exchange endpoints
+	SnapshotInterval    time.Duration
+	StrictAlpha         bool // fail with 500 when an alpha lookup returns several countries
+	FlagFetchTimeout    time.Duration
+	FlagMaxBytes        int64
+	NeighbourWorkers    int // concurrent country lookups per request
+	CountryCacheTTL     time.Duration
+	CountryCacheMax     int
+	RatesCacheTTL       time.Duration // rate tables per base (and date)
+	RatesCacheMax       int
+	ServeStaleOnError   bool // answer with an expired country entry when the upstream fails
+	ResponseTimestamps  bool // add generated_at to info, exchange and status without ?withTimestamp=true
+	UpstreamAttempts    int  // tries per country, rates and probe call, the first one included
+	UpstreamRetryDelay  time.Duration
+	Debug               bool // log debug details such as detected upstream response shapes
+	CountriesBaseURL    string
+	CurrencyBaseURL     string
+	CORSAllowOrigin     string
+	BreakerFailures     int // consecutive currency failures that open the breaker
+	BreakerWindow       time.Duration
+	BreakerCooldown     time.Duration
+	GzipMinBytes        int // smallest body that is gzip-compressed
+	RateLimitRPS        int // requests per second per client IP; 0 disables the limit
+	RateLimitBurst      int
+	TrustForwardedFor   bool          // take the client IP from X-Forwarded-For
+	UpstreamTimeout     time.Duration // per upstream call
+	ExchangeDeadline    time.Duration // for a whole exchange request, fan-out included
+}
+
+var (
+	configOnce sync.Once
+	configMu   sync.RWMutex
+	config     *Config
+)
+
+// LoadConfig reads the environment on first use and returns the same
+// configuration afterwards. Safe for concurrent use.
+func LoadConfig() *Config {
+	configOnce.Do(func() {
+		c := configFromEnv()
+		configMu.Lock()
+		config = c
+		configMu.Unlock()
+	})
+	configMu.RLock()
+	defer configMu.RUnlock()
+	return config
+}
+
+// SetConfig replaces the active configuration, e.g. to inject settings in
+// tests, and applies it like main does (see applyConfig). The environment is
+// not read afterwards. StatsD and the status webhook are only started by
+// main, and the response cache keeps the size it was first used with.
+func SetConfig(c *Config) {
+	configOnce.Do(func() {})
+	configMu.Lock()
+	config = c
+	configMu.Unlock()
+	applyConfig(c)
+}
+
+// applyConfig wires the settings that are not read per request into the
+// upstream clients, caches and rate limiter. Not safe while requests are
+// being served.
+func applyConfig(cfg *Config) {
+	transport := newUpstreamTransport(cfg)
+	httpClient.Transport = transport
+	httpClient.Timeout = cfg.UpstreamTimeout
+	bulkClient.Transport = transport
+	flagClient.Transport = transport
+
+	countryCache.configure(cfg.CountryCacheTTL, cfg.CountryCacheMax)
+	ratesCache.configure(cfg.RatesCacheTTL, cfg.RatesCacheMax)
+	ratePairCache.configure(cfg.RatesCacheTTL, ratePairCacheMax)
+	exchangeFullCache.configure(cfg.RatesCacheTTL, exchangeFullCacheMax)
+	currencyRatesCache.configure(cfg.RatesCacheTTL, currencyRatesCacheMax)
+
+	configureRateLimiter(cfg)
+}
+
+// DefaultConfig returns the configuration used when no env vars are set.
+func DefaultConfig() *Config {
+	return &Config{
+		Port:                "8080",
+		RequestIDHeader:     defaultRequestIDHeader,
+		AllFetchTimeout:     defaultAllFetchTimeout,
+		DialTimeout:         defaultDialTimeout,
+		TLSHandshakeTimeout: defaultTLSHandshakeTimeout,
+		StatusProbeMethod:   http.MethodGet,
+		StatusProbeSamples:  1,
+		StatusPollInterval:  defaultStatusPollInterval,
+		SlowRequest:         defaultSlowRequest,
+		CurrencyPick:        currencyPickSorted,
+		ResponseCacheMax:    defaultResponseCacheMax,
+		EnableInfo:          true,
+		EnableExchange:      true,
+		SnapshotInterval:    defaultSnapshotInterval,
+		FlagFetchTimeout:    defaultFlagFetchTimeout,
+		FlagMaxBytes:        defaultFlagMaxBytes,
+		NeighbourWorkers:    defaultNeighbourWorkers,
+		CountryCacheTTL:     defaultCountryCacheTTL,
+		CountryCacheMax:     defaultCountryCacheMax,
+		RatesCacheTTL:       defaultRatesCacheTTL,
+		RatesCacheMax:       defaultRatesCacheMax,
+		UpstreamAttempts:    defaultUpstreamAttempts,
+		UpstreamRetryDelay:  defaultUpstreamRetryDelay,
+		CountriesBaseURL:    defaultCountriesBaseURL,
+		CurrencyBaseURL:     defaultCurrencyBaseURL,
+		CORSAllowOrigin:     defaultCORSAllowOrigin,
+		BreakerFailures:     defaultBreakerFailures,
+		BreakerWindow:       defaultBreakerWindow,
+		BreakerCooldown:     defaultBreakerCooldown,
+		GzipMinBytes:        defaultGzipMinBytes,
+		RateLimitRPS:        defaultRateLimitRPS,
+		RateLimitBurst:      defaultRateLimitBurst,
+		UpstreamTimeout:     defaultUpstreamTimeout,
+		ExchangeDeadline:    defaultExchangeDeadline,
+	}
+}
+
+func configFromEnv() *Config {
+	c := DefaultConfig()
+
+	if port := os.Getenv("PORT"); port != "" {
+		c.Port = port
+	} else {
+		log.Println("$PORT has not been set. Default: 8080")
+	}
+
+	if h := strings.TrimSpace(os.Getenv("REQUEST_ID_HEADER")); h != "" {
+		c.RequestIDHeader = http.CanonicalHeaderKey(h)
+	}
+	c.AllFetchTimeout = envDuration("ALL_FETCH_TIMEOUT", c.AllFetchTimeout)
+	c.DialTimeout = envDuration("UPSTREAM_DIAL_TIMEOUT", c.DialTimeout)
+	c.TLSHandshakeTimeout = envDuration("UPSTREAM_TLS_TIMEOUT", c.TLSHandshakeTimeout)
+
+	switch m := strings.ToUpper(strings.TrimSpace(os.Getenv("STATUS_PROBE_METHOD"))); m {
+	case "":
+	case http.MethodGet, http.MethodHead:
+		c.StatusProbeMethod = m
+	default:
+		log.Printf("$STATUS_PROBE_METHOD=%q is not GET or HEAD. Default: %s", m, c.StatusProbeMethod)
+	}
+
+	c.StrictParams = envBool("STRICT_PARAMS", c.StrictParams)
+	c.RequireBaseRates = envBool("REQUIRE_BASE_RATES", c.RequireBaseRates)
+	c.StatusProbeSamples = envInt("STATUS_PROBE_SAMPLES", c.StatusProbeSamples, 1, maxStatusProbeSamples)
+	c.LenientCodes = envBool("LENIENT_CODES", c.LenientCodes)
+	c.StatsDAddr = strings.TrimSpace(os.Getenv("STATSD_ADDR"))
+	c.StatusWebhookURL = strings.TrimSpace(os.Getenv("STATUS_WEBHOOK_URL"))
+	c.StatusPollInterval = envDuration("STATUS_POLL_INTERVAL", c.StatusPollInterval)
+	switch p := strings.ToLower(strings.TrimSpace(os.Getenv("CURRENCY_PICK"))); p {
+	case "":
+	case currencyPickSorted, currencyPickFirstListed:
+		c.CurrencyPick = p
+	default:
+		log.Printf("$CURRENCY_PICK=%q is not sorted or first-listed. Default: %s", p, c.CurrencyPick)
+	}
+	c.EnableInfo = envBool("ENABLE_INFO", c.EnableInfo)
+	c.EnableExchange = envBool("ENABLE_EXCHANGE", c.EnableExchange)
+	c.FlagFetchTimeout = envDuration("FLAG_FETCH_TIMEOUT", c.FlagFetchTimeout)
+	c.FlagMaxBytes = int64(envInt("FLAG_MAX_BYTES", int(c.FlagMaxBytes), 1, 16<<20))
+	c.CountryCacheTTL = envDuration("COUNTRY_CACHE_TTL", c.CountryCacheTTL)
+	c.CountryCacheMax = envInt("COUNTRY_CACHE_MAX", c.CountryCacheMax, 1, 100000)
+	c.RatesCacheTTL = envDuration("RATES_CACHE_TTL", c.RatesCacheTTL)
+	c.RatesCacheMax = envInt("RATES_CACHE_MAX", c.RatesCacheMax, 1, 10000)
+	c.ServeStaleOnError = envBool("SERVE_STALE_ON_ERROR", c.ServeStaleOnError)
+	c.ResponseTimestamps = envBool("RESPONSE_TIMESTAMPS", c.ResponseTimestamps)
+	c.UpstreamAttempts = envInt("UPSTREAM_ATTEMPTS", c.UpstreamAttempts, 1, maxUpstreamAttempts)
+	c.UpstreamRetryDelay = envDuration("UPSTREAM_RETRY_DELAY", c.UpstreamRetryDelay)
+	c.Debug = envBool("DEBUG", c.Debug)
+	c.CountriesBaseURL = envURL("COUNTRIES_BASE_URL", c.CountriesBaseURL)
+	c.CurrencyBaseURL = envURL("CURRENCY_BASE_URL", c.CurrencyBaseURL)
+	c.BreakerFailures = envInt("CURRENCY_BREAKER_FAILURES", c.BreakerFailures, 1, 1000)
+	c.BreakerWindow = envDuration("CURRENCY_BREAKER_WINDOW", c.BreakerWindow)
+	c.BreakerCooldown = envDuration("CURRENCY_BREAKER_COOLDOWN", c.BreakerCooldown)
+	c.GzipMinBytes = envInt("GZIP_MIN_BYTES", c.GzipMinBytes, 0, 1<<20)
+	c.RateLimitRPS = envInt("RATE_LIMIT_RPS", c.RateLimitRPS, 0, maxRateLimitRPS)
+	c.RateLimitBurst = envInt("RATE_LIMIT_BURST", c.RateLimitBurst, 1, maxRateLimitBurst)
+	c.TrustForwardedFor = envBool("TRUST_FORWARDED_FOR", c.TrustForwardedFor)
+	c.UpstreamTimeout = envDuration("UPSTREAM_TIMEOUT", c.UpstreamTimeout)
+	c.ExchangeDeadline = envDuration("EXCHANGE_DEADLINE", c.ExchangeDeadline)
+	if o := strings.TrimSpace(os.Getenv("CORS_ALLOW_ORIGIN")); o != "" {
+		c.CORSAllowOrigin = o
+	}
+	c.NeighbourWorkers = envInt("NEIGHBOUR_WORKERS", c.NeighbourWorkers, 1, maxNeighbourWorkers)
+	c.StrictAlpha = envBool("STRICT_ALPHA", c.StrictAlpha)
+	c.SnapshotInterval = envDuration("SNAPSHOT_INTERVAL", c.SnapshotInterval)
+	c.ResponseCacheTTL = envDuration("RESPONSE_CACHE_TTL", c.ResponseCacheTTL)
+	c.ResponseCacheMax = envInt("RESPONSE_CACHE_MAX", c.ResponseCacheMax, 1, 100000)
+	c.SlowRequest = time.Duration(envInt("SLOW_REQUEST_MS", int(c.SlowRequest/time.Millisecond), 1, 600000)) * time.Millisecond
+
+	return c
+}
+
+// debugf logs with a DEBUG prefix, only when DEBUG is on.
+func debugf(format string, args ...any) {
+	if LoadConfig().Debug {
+		log.Printf("DEBUG "+format, args...)
+	}
+}
+
+// envBool reads a boolean ("true", "1", "false", ...) from the environment,
+// falling back to def when unset or invalid.
+func envBool(name string, def bool) bool {
+	raw := strings.TrimSpace(os.Getenv(name))
+	if raw == "" {
+		return def
+	}
+	b, err := strconv.ParseBool(raw)
+	if err != nil {
+		log.Printf("$%s=%q is not a valid boolean. Default: %t", name, raw, def)
+		return def
+	}
+	return b
+}
+
+// envInt reads an integer in [lo, hi] from the environment, falling back to
+// def when unset, invalid or out of range.
+func envInt(name string, def, lo, hi int) int {
+	raw := strings.TrimSpace(os.Getenv(name))
+	if raw == "" {
+		return def
+	}
+	n, err := strconv.Atoi(raw)
+	if err != nil || n < lo || n > hi {
+		log.Printf("$%s=%q is not an integer between %d and %d. Default: %d", name, raw, lo, hi, def)
+		return def
+	}
+	return n
+}
+
+// envURL reads an absolute http(s) base URL from the environment, without a
+// trailing slash, falling back to def when unset or invalid.
+func envURL(name, def string) string {
+	raw := strings.TrimRight(strings.TrimSpace(os.Getenv(name)), "/")
+	if raw == "" {
+		return def
+	}
+	u, err := url.Parse(raw)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		log.Printf("$%s=%q is not an http(s) URL. Default: %s", name, raw, def)
+		return def
+	}
+	return raw
+}
+
+// envDuration reads a Go duration (e.g. "30s") from the environment,
+// falling back to def when unset or invalid.
+func envDuration(name string, def time.Duration) time.Duration {
+	raw := strings.TrimSpace(os.Getenv(name))
+	if raw == "" {
+		return def
+	}
+	d, err := time.ParseDuration(raw)
+	if err != nil || d <= 0 {
+		log.Printf("$%s=%q is not a valid duration. Default: %s", name, raw, def)
+		return def
+	}
+	return d
+}
@@ -0,0 +1,61 @@
+package main
+
+import (
+	"bytes"
+	"encoding/csv"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+/* -------------------- CSV encoding -------------------- */
+
+// CSV is offered for spreadsheet imports. Only flat fields are written;
+// list and map fields are joined with semicolons.
+
+const csvContentType = "text/csv; charset=utf-8"
+
+// csvMarshaler is implemented by responses that have a CSV form: a header
+// row followed by the data rows.
+type csvMarshaler interface {
+	marshalCSV() [][]string
+}
+
+// wantsCSV reports whether the client asked for CSV in Accept.
+func wantsCSV(r *http.Request) bool {
+	return acceptsMediaType(r, "text/csv")
+}
+
+// encodeCSV renders rows with the standard CSV quoting rules.
+func encodeCSV(rows [][]string) []byte {
+	var buf bytes.Buffer
+	cw := csv.NewWriter(&buf)
+	_ = cw.WriteAll(rows) // writes to a bytes.Buffer cannot fail
+	return buf.Bytes()
+}
+
+// marshalCSV writes one row for the country. Languages are listed by name in
+// alphabetical order; neighbours, extras and the other opt-in fields are left
+// out.
+func (resp infoResponse) marshalCSV() [][]string {
+	languages := make([]string, 0, len(resp.Languages))
+	for _, name := range resp.Languages {
+		languages = append(languages, name)
+	}
+	sort.Strings(languages)
+
+	return [][]string{
+		{"name", "capital", "population", "area", "continents", "languages", "borders", "flag"},
+		{
+			resp.Name,
+			resp.Capital,
+			strconv.FormatInt(resp.Population, 10),
+			strconv.FormatFloat(resp.Area, 'f', -1, 64),
+			strings.Join(resp.Continents, ";"),
+			strings.Join(languages, ";"),
+			strings.Join(resp.Borders, ";"),
+			resp.Flag,
+		},
+	}
+}
@@ -0,0 +1,166 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sort"
+	"strings"
+)
+
+/* -------------------- CURRENCY rates endpoint -------------------- */
+
+// Currencies like EUR are used by dozens of countries with even more
+// neighbours, so the border lookups are capped and results cached long.
+const maxCurrencyBorderLookups = 60
+
+type currencyRatesResponse struct {
+	BaseCurrency  string             `json:"base-currency"`
+	UsedBy        []string           `json:"used-by"` // countries using the base currency
+	ExchangeRates map[string]float64 `json:"exchange-rates"`
+	Truncated     bool               `json:"truncated,omitempty"` // border lookups hit the cap
+}
+
+const currencyRatesCacheMax = 128
+
+// Responses embed exchange rates, so they expire with the rate tables
+// (RATES_CACHE_TTL) and are configured by applyConfig
+var currencyRatesCache = newTTLCache[*currencyRatesResponse](defaultRatesCacheTTL, currencyRatesCacheMax)
+
+// CurrencyHandler serves /countryinfo/v1/currency/{code}/rates
+func CurrencyHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		writeJSONError(w, http.StatusMethodNotAllowed, "method not allowed")
+		return
+	}
+	if !checkQueryParams(w, r) {
+		return
+	}
+
+	const form = "/countryinfo/v1/currency/{currency_code}/rates"
+	segs, ok := pathSegments(w, r, "/countryinfo/v1/currency/", 2, 2, form)
+	if !ok {
+		return
+	}
+	if segs[1] != "rates" {
+		writeJSONError(w, http.StatusNotFound, "unknown resource, expected "+form)
+		return
+	}
+	code := strings.ToUpper(strings.TrimSpace(segs[0]))
+	if !validCurrencyCode(code) {
+		writeJSONError(w, http.StatusBadRequest, "currency_code must be 3 letters, e.g. /countryinfo/v1/currency/eur/rates")
+		return
+	}
+
+	if cached, ok := currencyRatesCache.get(code); ok {
+		writeJSON(w, http.StatusOK, cached)
+		return
+	}
+
+	users, st, err := fetchCountriesByCurrency(r.Context(), code)
+	if err != nil {
+		writeUpstreamError(w, err, "failed to call countries service")
+		return
+	}
+	if st == http.StatusNotFound || (st == http.StatusOK && len(users) == 0) {
+		writeJSONError(w, http.StatusNotFound, "no country uses currency "+code)
+		return
+	}
+	if st != http.StatusOK {
+		writeJSONError(w, http.StatusBadGateway, "countries service returned non-200")
+		return
+	}
+
+	out := &currencyRatesResponse{BaseCurrency: code, ExchangeRates: map[string]float64{}}
+
+	// Union of borders of every using country
+	seen := make(map[string]bool)
+	var borders []string
+	for _, c := range users {
+		out.UsedBy = append(out.UsedBy, c.Name.Common)
+		for _, b := range c.Borders {
+			b = strings.ToUpper(strings.TrimSpace(b))
+			if b == "" || seen[b] {
+				continue
+			}
+			seen[b] = true
+			borders = append(borders, b)
+		}
+	}
+	sort.Strings(out.UsedBy)
+	sort.Strings(borders)
+	if len(borders) > maxCurrencyBorderLookups {
+		borders = borders[:maxCurrencyBorderLookups]
+		out.Truncated = true
+	}
+
+	neighbours, err := fetchCountriesConcurrently(r.Context(), borders)
+	if err != nil {
+		writeUpstreamError(w, err, err.Error())
+		return
+	}
+
+	targets := make(map[string]struct{})
+	for _, nc := range neighbours {
+		ccy := primaryCurrency(nc)
+		if len(ccy) == 3 && ccy != code {
+			targets[ccy] = struct{}{}
+		}
+	}
+
+	if len(targets) > 0 {
+		ratesResp, st, err := fetchRates(r.Context(), code)
+		if err != nil {
+			writeRatesError(w, err)
+			return
+		}
+		if st != http.StatusOK || ratesResp == nil {
+			writeJSONError(w, http.StatusBadGateway, "currency service returned non-200")
+			return
+		}
+		if ratesResp.Result != "" && ratesResp.Result != "success" {
+			writeJSONError(w, http.StatusBadGateway, "currency service returned result != success")
+			return
+		}
+		for ccy := range targets {
+			if v, ok := ratesResp.Rates[ccy]; ok {
+				out.ExchangeRates[ccy] = v
+			}
+		}
+	}
+
+	currencyRatesCache.set(code, out)
+	writeJSON(w, http.StatusOK, out)
+}
+
+// fetchCountriesByCurrency returns every country using the currency code.
+// Like country lookups it is retried and counted by outcome.
+func fetchCountriesByCurrency(ctx context.Context, code string) ([]countriesCountry, int, error) {
+	out, st, err := fetchCountriesByCurrencyUpstream(ctx, code)
+	recordFetch("currency_countries", st, err)
+	return out, st, err
+}
+
+func fetchCountriesByCurrencyUpstream(ctx context.Context, code string) ([]countriesCountry, int, error) {
+	url := fmt.Sprintf("%s/currency/%s", countriesBaseURL(), strings.ToLower(code))
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+	if err != nil {
+		return nil, 0, err
+	}
+	resp, err := doUpstreamRetry(httpClient, req)
+	if err != nil {
+		return nil, 0, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, resp.StatusCode, nil
+	}
+
+	var out []countriesCountry
+	if err := json.NewDecoder(resp.Body).Decode(&out); err != nil {
+		return nil, 0, &upstreamDecodeError{service: "countries", err: err}
+	}
+	return out, http.StatusOK, nil
+}
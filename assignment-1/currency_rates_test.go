@@ -0,0 +1,41 @@
+package main
+
+import (
+	"net/http"
+	"sync/atomic"
+	"testing"
+)
+
+// A rate table the currency service marks as failed is a 502, and it is not
+// cached: the next request gets the rates once the service recovers.
+func TestCurrencyRatesRejectsFailedResult(t *testing.T) {
+	var failing atomic.Bool
+	failing.Store(true)
+	healthy := currencyStubHandler(nordicRates)
+	currency := newUpstreamStub(t, func(w http.ResponseWriter, r *http.Request) {
+		if failing.Load() {
+			_, _ = w.Write([]byte(`{"result":"error","error-type":"quota-reached"}`))
+			return
+		}
+		healthy(w, r)
+	})
+	countries := newUpstreamStub(t, countriesStubHandler(t, nordicFixtures...))
+	useUpstreams(t, countries, currency, nil)
+
+	var failed struct {
+		Error string `json:"error"`
+	}
+	getJSON(t, CurrencyHandler, "/countryinfo/v1/currency/nok/rates", http.StatusBadGateway, &failed)
+	if failed.Error != "currency service returned result != success" {
+		t.Errorf("error %q", failed.Error)
+	}
+
+	failing.Store(false)
+	var resp currencyRatesResponse
+	getJSON(t, CurrencyHandler, "/countryinfo/v1/currency/nok/rates", http.StatusOK, &resp)
+	for _, ccy := range []string{"SEK", "EUR", "RUB"} {
+		if _, ok := resp.ExchangeRates[ccy]; !ok {
+			t.Errorf("%s missing from %v", ccy, resp.ExchangeRates)
+		}
+	}
+}
@@ -0,0 +1,92 @@
+package main
+
+import (
+	"net/http"
+	"sort"
+	"strconv"
+	"time"
+)
+
+/* -------------------- CURRENCY-USAGE endpoint -------------------- */
+
+type currencyUsage struct {
+	Currency  string `json:"currency"`
+	Countries int    `json:"countries"`
+}
+
+// The ranking only changes when the dataset does, so it is cached as long.
+var currencyUsageCache = newTTLCache[[]currencyUsage](time.Hour, 2)
+
+// CurrencyUsageHandler serves /countryinfo/v1/currency-usage?limit=N&independentOnly=true
+func CurrencyUsageHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		writeJSONError(w, http.StatusMethodNotAllowed, "method not allowed")
+		return
+	}
+	if !checkQueryParams(w, r, "limit", "independentOnly") {
+		return
+	}
+
+	limit := 0
+	if raw := r.URL.Query().Get("limit"); raw != "" {
+		n, err := strconv.Atoi(raw)
+		if err != nil || n < 1 {
+			writeJSONError(w, http.StatusBadRequest, "limit must be a positive integer")
+			return
+		}
+		limit = n
+	}
+
+	cacheKey := "usage"
+	if independentOnly(r) {
+		cacheKey = "usage:independent"
+	}
+
+	ranking, ok := currencyUsageCache.get(cacheKey)
+	if !ok {
+		all, st, err := getAllCountries(r.Context())
+		if err != nil {
+			writeUpstreamError(w, err, "failed to call countries service")
+			return
+		}
+		if st != http.StatusOK {
+			writeJSONError(w, http.StatusBadGateway, "countries service returned non-200")
+			return
+		}
+		if independentOnly(r) {
+			all = filterIndependent(all)
+		}
+		ranking = rankCurrencyUsage(all)
+		currencyUsageCache.set(cacheKey, ranking)
+	}
+
+	if limit > 0 && limit < len(ranking) {
+		ranking = ranking[:limit]
+	}
+	writeJSON(w, http.StatusOK, ranking)
+}
+
+// rankCurrencyUsage counts each country once, under its primary currency,
+// and orders by usage descending, then by code.
+func rankCurrencyUsage(all []countriesCountry) []currencyUsage {
+	counts := make(map[string]int)
+	for _, c := range all {
+		ccy := primaryCurrency(&c)
+		if len(ccy) != 3 {
+			continue
+		}
+		counts[ccy]++
+	}
+
+	out := make([]currencyUsage, 0, len(counts))
+	for ccy, n := range counts {
+		out = append(out, currencyUsage{Currency: ccy, Countries: n})
+	}
+	sort.Slice(out, func(i, j int) bool {
+		if out[i].Countries != out[j].Countries {
+			return out[i].Countries > out[j].Countries
+		}
+		return out[i].Currency < out[j].Currency
+	})
+	return out
+}
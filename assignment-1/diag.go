@@ -0,0 +1,133 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"sync"
+	"time"
+)
+
+/* -------------------- DIAG endpoint -------------------- */
+
+// diagResponse reports effective runtime settings and timed probes that are
+// useful when debugging upstream behaviour.
+type diagResponse struct {
+	// One timed probe per upstream in use, through the shared httpClient and
+	// without retries; an upstream no enabled endpoint uses reports "disabled"
+	RestCountriesStatus    any   `json:"restcountries_status"`
+	RestCountriesLatencyMs int64 `json:"restcountries_latency_ms"`
+	RestCountriesInTime    bool  `json:"restcountries_within_timeout"`
+	CurrencyStatus         any   `json:"currency_status"`
+	CurrencyLatencyMs      int64 `json:"currency_latency_ms"`
+	CurrencyInTime         bool  `json:"currency_within_timeout"`
+	Healthy                bool  `json:"healthy"` // every probed upstream 200 within the timeout
+
+	AllFetchTimeoutMs     int64 `json:"all_fetch_timeout_ms"`
+	DialTimeoutMs         int64 `json:"dial_timeout_ms"`
+	TLSHandshakeTimeoutMs int64 `json:"tls_handshake_timeout_ms"`
+	// Upstream call durations since startup, per upstream
+	UpstreamLatency map[string]latencySummary `json:"upstream_latency"`
+	CountryCache    countryCacheStats         `json:"country_cache"`
+	RatesCache      ratesCacheStats           `json:"rates_cache"`
+}
+
+type countryCacheStats struct {
+	TTLMs      int64 `json:"ttl_ms"`
+	MaxEntries int   `json:"max_entries"`
+	Entries    int   `json:"entries"`
+	Hits       int64 `json:"hits"`
+	StaleHits  int64 `json:"stale_hits"` // served stale while refreshing
+	Misses     int64 `json:"misses"`
+	// Misses answered from an expired entry because the upstream failed
+	StaleOnError int64 `json:"stale_on_error"`
+}
+
+type ratesCacheStats struct {
+	TTLMs      int64 `json:"ttl_ms"`
+	MaxEntries int   `json:"max_entries"`
+	Entries    int   `json:"entries"`
+	Hits       int64 `json:"hits"`
+	Misses     int64 `json:"misses"`
+}
+
+func DiagHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		writeJSONError(w, http.StatusMethodNotAllowed, "method not allowed")
+		return
+	}
+	if !checkQueryParams(w, r) {
+		return
+	}
+
+	cfg := LoadConfig()
+	resp := diagResponse{
+		RestCountriesStatus: upstreamDisabled,
+		CurrencyStatus:      upstreamDisabled,
+
+		AllFetchTimeoutMs:     cfg.AllFetchTimeout.Milliseconds(),
+		DialTimeoutMs:         cfg.DialTimeout.Milliseconds(),
+		TLSHandshakeTimeoutMs: cfg.TLSHandshakeTimeout.Milliseconds(),
+		UpstreamLatency: map[string]latencySummary{
+			"restcountries": restCountriesLatency.summary(),
+			"currency":      currencyLatency.summary(),
+			"other":         otherLatency.summary(),
+		},
+		CountryCache: countryCacheStats{
+			TTLMs:      cfg.CountryCacheTTL.Milliseconds(),
+			MaxEntries: cfg.CountryCacheMax,
+			Entries:    countryCache.len(),
+			Hits:       countryCacheHits.Load(),
+			StaleHits:  countryCacheStale.Load(),
+			Misses:     countryCacheMisses.Load(),
+
+			StaleOnError: countryCacheStaleOnError.Load(),
+		},
+		RatesCache: ratesCacheStats{
+			TTLMs:      cfg.RatesCacheTTL.Milliseconds(),
+			MaxEntries: cfg.RatesCacheMax,
+			Entries:    ratesCache.len(),
+			Hits:       ratesCacheHits.Load(),
+			Misses:     ratesCacheMisses.Load(),
+		},
+	}
+
+	// Both probes run at once; each goroutine only writes its own fields
+	probeRest, probeCurrency := upstreamsInUse(cfg)
+	var (
+		wg                 sync.WaitGroup
+		restOK, currencyOK = true, true
+	)
+	if probeRest {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			st, elapsed := timedProbe(r.Context(), probeRestCountries)
+			resp.RestCountriesStatus = st
+			resp.RestCountriesLatencyMs = elapsed.Milliseconds()
+			resp.RestCountriesInTime = elapsed < httpClient.Timeout
+			restOK = st == http.StatusOK && resp.RestCountriesInTime
+		}()
+	}
+	if probeCurrency {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			st, elapsed := timedProbe(r.Context(), probeCurrencyService)
+			resp.CurrencyStatus = st
+			resp.CurrencyLatencyMs = elapsed.Milliseconds()
+			resp.CurrencyInTime = elapsed < httpClient.Timeout
+			currencyOK = st == http.StatusOK && resp.CurrencyInTime
+		}()
+	}
+	wg.Wait()
+	resp.Healthy = restOK && currencyOK
+	writeJSON(w, http.StatusOK, resp)
+}
+
+// timedProbe runs one probe with a single attempt, so the measured round
+// trip holds no retries or backoff.
+func timedProbe(ctx context.Context, probe func(context.Context) int) (int, time.Duration) {
+	start := time.Now()
+	st := probe(withSingleAttempt(ctx))
+	return st, time.Since(start)
+}
@@ -0,0 +1,53 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"net/http"
+	"strings"
+)
+
+/* -------------------- ETag / conditional requests -------------------- */
+
+// writeNegotiatedETag is writeNegotiated for data that rarely changes: a 200
+// carries an ETag, and a request whose If-None-Match holds that ETag gets
+// 304 Not Modified without a body. The ETag hashes the encoded body and its
+// Content-Type, so it is the same for identical upstream data and differs
+// between JSON, protobuf and CSV. It is weak because withGzip may change the
+// bytes on the wire.
+func writeNegotiatedETag(w http.ResponseWriter, r *http.Request, status int, v any) {
+	contentType, body := encodeNegotiated(r, v)
+	if status != http.StatusOK {
+		writeEncoded(w, r, status, contentType, body)
+		return
+	}
+
+	etag := bodyETag(contentType, body)
+	w.Header().Set("ETag", etag)
+	if etagMatches(r.Header.Get("If-None-Match"), etag) {
+		w.WriteHeader(http.StatusNotModified)
+		return
+	}
+	writeEncoded(w, r, status, contentType, body)
+}
+
+func bodyETag(contentType string, body []byte) string {
+	h := sha256.New()
+	h.Write([]byte(contentType))
+	h.Write([]byte{0})
+	h.Write(body)
+	return `W/"` + hex.EncodeToString(h.Sum(nil)[:16]) + `"`
+}
+
+// etagMatches applies If-None-Match's weak comparison: "*" or any listed
+// tag equal to etag, ignoring W/ prefixes.
+func etagMatches(header, etag string) bool {
+	want := strings.TrimPrefix(etag, "W/")
+	for _, tag := range strings.Split(header, ",") {
+		tag = strings.TrimSpace(tag)
+		if tag == "*" || strings.TrimPrefix(tag, "W/") == want {
+			return true
+		}
+	}
+	return false
+}
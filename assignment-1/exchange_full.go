@@ -0,0 +1,121 @@
+package main
+
+import "net/http"
+
+/* -------------------- EXCHANGE full matrix -------------------- */
+
+// The full matrix multiplies upstream calls (one rate table per base currency
+// plus one lookup per neighbour), so both dimensions are capped and the
+// result is cached per country.
+const (
+	maxFullBaseCurrencies = 4
+	maxFullNeighbours     = 16
+	exchangeFullCacheMax  = 256
+)
+
+// Matrices are only as fresh as the rate tables they are built from, so they
+// share RATES_CACHE_TTL (configured by applyConfig)
+var exchangeFullCache = newTTLCache[*exchangeFullResponse](defaultRatesCacheTTL, exchangeFullCacheMax)
+
+type exchangeFullResponse struct {
+	Country        string                        `json:"country"`
+	BaseCurrencies []string                      `json:"base-currencies"`
+	ExchangeRates  map[string]map[string]float64 `json:"exchange-rates"` // base -> neighbour currency -> rate
+}
+
+// exchangeFullHandler serves /countryinfo/v1/exchange/{code}/full
+func exchangeFullHandler(w http.ResponseWriter, r *http.Request, code string) {
+	if !checkQueryParams(w, r) {
+		return
+	}
+	if !validISO2(code) {
+		writeJSONError(w, http.StatusBadRequest, "two_letter_country_code must be 2 letters (ISO 3166-2), e.g. /countryinfo/v1/exchange/no/full")
+		return
+	}
+
+	if cached, ok := exchangeFullCache.get(code); ok {
+		writeJSON(w, http.StatusOK, cached)
+		return
+	}
+
+	input, st, err := fetchCountryAlpha(r.Context(), code)
+	if err != nil {
+		writeUpstreamError(w, err, "failed to call countries service")
+		return
+	}
+	if st == http.StatusNotFound || (st == http.StatusOK && input == nil) {
+		writeJSONError(w, http.StatusNotFound, "country not found")
+		return
+	}
+	if st != http.StatusOK {
+		writeJSONError(w, http.StatusBadGateway, "countries service returned non-200")
+		return
+	}
+
+	bases := currencyCodesSorted(input.Currencies)
+	if len(bases) == 0 {
+		writeJSONError(w, http.StatusBadGateway, "input country has no valid currency")
+		return
+	}
+	if len(bases) > maxFullBaseCurrencies {
+		bases = bases[:maxFullBaseCurrencies]
+	}
+
+	capped := *input
+	if len(capped.Borders) > maxFullNeighbours {
+		capped.Borders = capped.Borders[:maxFullNeighbours]
+	}
+	neighbours, err := fetchNeighbours(r.Context(), &capped)
+	if err != nil {
+		writeUpstreamError(w, err, err.Error())
+		return
+	}
+
+	// Every currency used by any neighbour, not just the first sorted one
+	neighCurrencies := make(map[string]struct{})
+	for _, nc := range neighbours {
+		for _, ccy := range currencyCodesSorted(nc.Currencies) {
+			neighCurrencies[ccy] = struct{}{}
+		}
+	}
+
+	matrix := make(map[string]map[string]float64, len(bases))
+	for _, base := range bases {
+		row := make(map[string]float64)
+		matrix[base] = row
+		if len(neighCurrencies) == 0 {
+			continue
+		}
+
+		ratesResp, st, err := fetchRates(r.Context(), base)
+		if err != nil {
+			writeRatesError(w, err)
+			return
+		}
+		if st != http.StatusOK || ratesResp == nil {
+			writeJSONError(w, http.StatusBadGateway, "currency service returned non-200")
+			return
+		}
+		if ratesResp.Result != "" && ratesResp.Result != "success" {
+			writeJSONError(w, http.StatusBadGateway, "currency service returned result != success")
+			return
+		}
+
+		for ccy := range neighCurrencies {
+			if ccy == base {
+				continue
+			}
+			if v, ok := ratesResp.Rates[ccy]; ok {
+				row[ccy] = v
+			}
+		}
+	}
+
+	out := &exchangeFullResponse{
+		Country:        input.Name.Common,
+		BaseCurrencies: bases,
+		ExchangeRates:  matrix,
+	}
+	exchangeFullCache.set(code, out)
+	writeJSON(w, http.StatusOK, out)
+}
@@ -0,0 +1,101 @@
+package main
+
+import "strconv"
+
+/* -------------------- INFO field presence -------------------- */
+
+// infoFieldSources maps info fields to the upstream keys they come from, in
+// response order.
+var infoFieldSources = []struct{ field, upstream string }{
+	{"name", "name"},
+	{"cca2", "cca2"},
+	{"cca3", "cca3"},
+	{"continents", "continents"},
+	{"region", "region"},
+	{"subregion", "subregion"},
+	{"population", "population"},
+	{"area", "area"},
+	{"languages", "languages"},
+	{"borders", "borders"},
+	{"flag", "flags"},
+	{"capital", "capital"},
+	{"independent", "independent"},
+	{"un_member", "unMember"},
+}
+
+// presentInfoFields lists the info fields backed by real upstream data, so
+// clients can tell "no data" from a zero value.
+func presentInfoFields(c *countriesCountry) []string {
+	out := []string{}
+	for _, f := range infoFieldSources {
+		if c.Present[f.upstream] {
+			out = append(out, f.field)
+		}
+	}
+	return out
+}
+
+/* -------------------- INFO extras -------------------- */
+
+// infoExtras holds optional upstream fields, only included with ?extras=true.
+type infoExtras struct {
+	Gini        *giniValue        `json:"gini,omitempty"` // omitted when the upstream has no data
+	CallingCode string            `json:"calling_code,omitempty"`
+	Tld         []string          `json:"tld,omitempty"`
+	StartOfWeek string            `json:"start_of_week,omitempty"`
+	PostalCode  *postalCodeFormat `json:"postal_code,omitempty"`
+	Status      string            `json:"status,omitempty"` // e.g. officially-assigned; user-assigned codes are not standard ISO
+}
+
+type giniValue struct {
+	Year  int     `json:"year"`
+	Value float64 `json:"value"`
+}
+
+func toInfoExtras(c *countriesCountry) *infoExtras {
+	return &infoExtras{
+		Gini:        latestGini(c.Gini),
+		CallingCode: callingCode(c.Idd),
+		Tld:         c.Tld,
+		StartOfWeek: c.StartOfWeek,
+		PostalCode:  postalCode(c.PostalCode),
+		Status:      c.Status,
+	}
+}
+
+// postalCode drops an upstream entry without a format, as some countries
+// carry an empty object.
+func postalCode(p *postalCodeFormat) *postalCodeFormat {
+	if p == nil || p.Format == "" {
+		return nil
+	}
+	return p
+}
+
+// callingCode joins the dialing root with its first suffix. Countries with
+// several suffixes (e.g. the +1 zone) get the first one only.
+func callingCode(idd countriesIdd) string {
+	if idd.Root == "" {
+		return ""
+	}
+	if len(idd.Suffixes) == 0 {
+		return idd.Root
+	}
+	return idd.Root + idd.Suffixes[0]
+}
+
+// latestGini picks the most recent year from the upstream year -> value map.
+// Keys that are not years are skipped.
+func latestGini(m map[string]float64) *giniValue {
+	var out *giniValue
+	for k, v := range m {
+		year, err := strconv.Atoi(k)
+		if err != nil {
+			continue
+		}
+		if out == nil || year > out.Year {
+			out = &giniValue{Year: year, Value: v}
+		}
+	}
+	return out
+}
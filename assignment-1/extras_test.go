@@ -0,0 +1,198 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"reflect"
+	"regexp"
+	"testing"
+)
+
+// infoExtrasFor GETs the info for code with ?extras=true and returns the raw
+// extras object, so omitted fields can be told from zero values.
+func infoExtrasFor(t *testing.T, code string) map[string]json.RawMessage {
+	t.Helper()
+	var resp struct {
+		Extras map[string]json.RawMessage `json:"extras"`
+	}
+	getJSON(t, InfoHandler, "/countryinfo/v1/info/"+code+"?extras=true", http.StatusOK, &resp)
+	if resp.Extras == nil {
+		t.Fatal("extras missing with ?extras=true")
+	}
+	return resp.Extras
+}
+
+// The most recent year of the upstream gini map is reported.
+func TestLatestGini(t *testing.T) {
+	tests := []struct {
+		name string
+		in   map[string]float64
+		want *giniValue
+	}{
+		{"no data", nil, nil},
+		{"one year", map[string]float64{"2018": 27.7}, &giniValue{2018, 27.7}},
+		{"several years", map[string]float64{"2015": 30.1, "2019": 27.6, "2018": 27.7}, &giniValue{2019, 27.6}},
+		{"non-year keys skipped", map[string]float64{"latest": 99, "2011": 32.5}, &giniValue{2011, 32.5}},
+		{"only non-year keys", map[string]float64{"n/a": 1}, nil},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := latestGini(tt.in); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("latestGini(%v) = %+v, want %+v", tt.in, got, tt.want)
+			}
+		})
+	}
+}
+
+// Norway has gini data for 2018 and 2019; Sweden has none, so the field is
+// omitted rather than zero.
+func TestInfoExtrasGini(t *testing.T) {
+	nordicUpstreams(t, nil)
+
+	var gini giniValue
+	if err := json.Unmarshal(infoExtrasFor(t, "no")["gini"], &gini); err != nil {
+		t.Fatalf("gini: %v", err)
+	}
+	if want := (giniValue{2019, 27.6}); gini != want {
+		t.Errorf("gini %+v, want %+v", gini, want)
+	}
+	if raw, ok := infoExtrasFor(t, "se")["gini"]; ok {
+		t.Errorf("gini %s for a country without data, want omitted", raw)
+	}
+}
+
+// The calling code is the dialing root plus the first suffix.
+func TestCallingCode(t *testing.T) {
+	tests := []struct {
+		name string
+		idd  countriesIdd
+		want string
+	}{
+		{"root and suffix", countriesIdd{Root: "+4", Suffixes: []string{"7"}}, "+47"},
+		{"several suffixes", countriesIdd{Root: "+1", Suffixes: []string{"201", "202", "203"}}, "+1201"},
+		{"root only", countriesIdd{Root: "+7"}, "+7"},
+		{"missing", countriesIdd{}, ""},
+		{"suffix without root", countriesIdd{Suffixes: []string{"7"}}, ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := callingCode(tt.idd); got != tt.want {
+				t.Errorf("callingCode(%+v) = %q, want %q", tt.idd, got, tt.want)
+			}
+		})
+	}
+}
+
+// Norway's calling code and TLD come through the info extras; Sweden has no
+// idd or tld upstream, so both are omitted.
+func TestInfoExtrasCallingCodeAndTld(t *testing.T) {
+	nordicUpstreams(t, nil)
+
+	extras := infoExtrasFor(t, "no")
+	if got := string(extras["calling_code"]); got != `"+47"` {
+		t.Errorf("calling_code %s, want \"+47\"", got)
+	}
+	if got := string(extras["tld"]); got != `[".no"]` {
+		t.Errorf("tld %s, want [\".no\"]", got)
+	}
+	extras = infoExtrasFor(t, "se")
+	for _, field := range []string{"calling_code", "tld"} {
+		if raw, ok := extras[field]; ok {
+			t.Errorf("%s %s for a country without data, want omitted", field, raw)
+		}
+	}
+}
+
+// startOfWeek passes through, including the days that differ from the
+// Monday default, and is omitted when the upstream has none.
+func TestInfoExtrasStartOfWeek(t *testing.T) {
+	const (
+		saudiArabia = `{"name":{"common":"Saudi Arabia"},"cca2":"SA","cca3":"SAU","region":"Asia","subregion":"Western Asia",` +
+			`"population":34813867,"area":2149690,"capital":["Riyadh"],"startOfWeek":"sunday"}`
+		iran = `{"name":{"common":"Iran"},"cca2":"IR","cca3":"IRN","region":"Asia","subregion":"Southern Asia",` +
+			`"population":83992953,"area":1648195,"capital":["Tehran"],"startOfWeek":"saturday"}`
+	)
+	countries := newUpstreamStub(t, countriesStubHandler(t, fixtureNorway, fixtureSweden, saudiArabia, iran))
+	useUpstreams(t, countries, nil, nil)
+
+	tests := []struct {
+		code string
+		want string // raw JSON; "" for omitted
+	}{
+		{"sa", `"sunday"`},
+		{"ir", `"saturday"`},
+		{"no", `"monday"`},
+		{"se", ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.code, func(t *testing.T) {
+			if got := string(infoExtrasFor(t, tt.code)["start_of_week"]); got != tt.want {
+				t.Errorf("start_of_week %s, want %s", got, tt.want)
+			}
+		})
+	}
+}
+
+// The postal code format passes through with its regex; an upstream entry
+// without a format is dropped like a missing one.
+func TestInfoExtrasPostalCode(t *testing.T) {
+	const denmark = `{"name":{"common":"Denmark"},"cca2":"DK","cca3":"DNK","region":"Europe","subregion":"Northern Europe",` +
+		`"population":5831404,"area":43094,"capital":["Copenhagen"],"postalCode":{"format":"","regex":""}}`
+	countries := newUpstreamStub(t, countriesStubHandler(t, fixtureNorway, fixtureSweden, denmark))
+	useUpstreams(t, countries, nil, nil)
+
+	tests := []struct {
+		code string
+		want *postalCodeFormat
+	}{
+		{"no", &postalCodeFormat{Format: "####", Regex: `^(\d{4})$`}},
+		{"se", nil}, // no postalCode upstream
+		{"dk", nil}, // an empty postalCode object
+	}
+	for _, tt := range tests {
+		t.Run(tt.code, func(t *testing.T) {
+			raw, ok := infoExtrasFor(t, tt.code)["postal_code"]
+			if tt.want == nil {
+				if ok {
+					t.Errorf("postal_code %s, want omitted", raw)
+				}
+				return
+			}
+			var got postalCodeFormat
+			if err := json.Unmarshal(raw, &got); err != nil {
+				t.Fatalf("postal_code %s: %v", raw, err)
+			}
+			if got != *tt.want {
+				t.Errorf("postal_code %+v, want %+v", got, *tt.want)
+			}
+			if _, err := regexp.Compile(got.Regex); err != nil {
+				t.Errorf("postal_code regex does not compile: %v", err)
+			}
+		})
+	}
+}
+
+// The ISO status passes through, so user-assigned codes (e.g. Kosovo's XK)
+// can be told from officially assigned ones.
+func TestInfoExtrasStatus(t *testing.T) {
+	const kosovo = `{"name":{"common":"Kosovo"},"cca2":"XK","cca3":"UNK","region":"Europe","subregion":"Southeast Europe",` +
+		`"population":1775378,"area":10908,"capital":["Pristina"],"independent":null,"status":"user-assigned"}`
+	countries := newUpstreamStub(t, countriesStubHandler(t, fixtureNorway, fixtureSweden, kosovo))
+	useUpstreams(t, countries, nil, nil)
+
+	tests := []struct {
+		code string
+		want string // raw JSON; "" for omitted
+	}{
+		{"no", `"officially-assigned"`},
+		{"xk", `"user-assigned"`},
+		{"se", ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.code, func(t *testing.T) {
+			if got := string(infoExtrasFor(t, tt.code)["status"]); got != tt.want {
+				t.Errorf("status %s, want %s", got, tt.want)
+			}
+		})
+	}
+}
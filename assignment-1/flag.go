@@ -0,0 +1,87 @@
+package main
+
+import (
+	"context"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"strings"
+	"time"
+)
+
+/* -------------------- FLAG inlining -------------------- */
+
+const (
+	defaultFlagMaxBytes     = 256 << 10 // flags are a few KB; anything this big is not a flag
+	defaultFlagFetchTimeout = 5 * time.Second
+)
+
+// flagClient is used only for images. It has no client timeout of its own;
+// each fetch gets FLAG_FETCH_TIMEOUT through its context instead.
+var flagClient = &http.Client{}
+
+// Flag images never change for a given URL, so encoded results are kept for a day.
+var flagCache = newTTLCache[string](24*time.Hour, 512)
+
+// inlineFlag returns the flag at url as a data: URI, or url itself if the
+// image cannot be fetched, so the client always gets something renderable.
+func inlineFlag(ctx context.Context, url string) string {
+	if url == "" {
+		return url
+	}
+	if dataURI, ok := flagCache.get(url); ok {
+		return dataURI
+	}
+
+	dataURI, err := fetchFlagDataURI(ctx, url)
+	if err != nil {
+		log.Printf("flag inline failed for %s: %v", url, err)
+		return url
+	}
+	flagCache.set(url, dataURI)
+	return dataURI
+}
+
+func fetchFlagDataURI(ctx context.Context, url string) (string, error) {
+	cfg := LoadConfig()
+	ctx, cancel := context.WithTimeout(ctx, cfg.FlagFetchTimeout)
+	defer cancel()
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+	if err != nil {
+		return "", err
+	}
+	resp, err := doUpstream(flagClient, req)
+	if errors.Is(err, context.DeadlineExceeded) {
+		return "", fmt.Errorf("flag fetch timed out after %s", cfg.FlagFetchTimeout)
+	}
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return "", fmt.Errorf("flag host returned %d", resp.StatusCode)
+	}
+
+	// Read one byte past the limit to detect oversized images
+	body, err := io.ReadAll(io.LimitReader(resp.Body, cfg.FlagMaxBytes+1))
+	if errors.Is(err, context.DeadlineExceeded) {
+		return "", fmt.Errorf("flag fetch timed out after %s", cfg.FlagFetchTimeout)
+	}
+	if err != nil {
+		return "", err
+	}
+	if int64(len(body)) > cfg.FlagMaxBytes {
+		return "", fmt.Errorf("flag image exceeds %d bytes", cfg.FlagMaxBytes)
+	}
+
+	contentType := resp.Header.Get("Content-Type")
+	if !strings.HasPrefix(contentType, "image/") {
+		contentType = "image/png"
+	}
+	return "data:" + contentType + ";base64," + base64.StdEncoding.EncodeToString(body), nil
+}
@@ -0,0 +1,164 @@
+package main
+
+import (
+	"context"
+	"encoding/base64"
+	"fmt"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+)
+
+// A 1x1 PNG, enough for the flag host stub
+var testFlagPNG, _ = base64.StdEncoding.DecodeString("iVBORw0KGgoAAAANSUhEUgAAAAEAAAABCAYAAAAfFcSJAAAADUlEQVR42mNk+M9QDwADhgGAWjR9awAAAABJRU5ErkJggg==")
+
+// flagUpstreams serves Norway with its flags on a stub image host whose
+// /w320/no.png answers with image.
+func flagUpstreams(t *testing.T, image http.HandlerFunc, edit func(*Config)) (flags *upstreamStub) {
+	t.Helper()
+	flags = newUpstreamStub(t, image)
+	norway := strings.ReplaceAll(fixtureNorway, "https://flagcdn.com", flags.URL)
+	countries := newUpstreamStub(t, countriesStubHandler(t, norway))
+	useUpstreams(t, countries, nil, edit)
+	return flags
+}
+
+// ?flagInline=true returns the PNG as a data: URI and keeps it cached; when
+// the image cannot be fetched the URL is returned instead.
+func TestInfoFlagInline(t *testing.T) {
+	tests := []struct {
+		name       string
+		image      http.HandlerFunc
+		wantPrefix string // of the flag field; the stub URL is substituted for {host}
+		wantHits   int    // image requests for two info requests
+	}{
+		{
+			name: "png",
+			image: func(w http.ResponseWriter, r *http.Request) {
+				w.Header().Set("Content-Type", "image/png")
+				_, _ = w.Write(testFlagPNG)
+			},
+			wantPrefix: "data:image/png;base64," + base64.StdEncoding.EncodeToString(testFlagPNG),
+			wantHits:   1,
+		},
+		{
+			name: "non-image content type",
+			image: func(w http.ResponseWriter, r *http.Request) {
+				w.Header().Set("Content-Type", "application/octet-stream")
+				_, _ = w.Write(testFlagPNG)
+			},
+			wantPrefix: "data:image/png;base64,",
+			wantHits:   1,
+		},
+		{
+			name: "not found",
+			image: func(w http.ResponseWriter, r *http.Request) {
+				w.WriteHeader(http.StatusNotFound)
+			},
+			wantPrefix: "{host}/w320/no.png",
+			wantHits:   2, // failures are not cached
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			flags := flagUpstreams(t, tt.image, nil)
+			want := strings.ReplaceAll(tt.wantPrefix, "{host}", flags.URL)
+
+			for range 2 {
+				var resp struct {
+					Flag string `json:"flag"`
+				}
+				getJSON(t, InfoHandler, "/countryinfo/v1/info/no?flagInline=true", http.StatusOK, &resp)
+				if !strings.HasPrefix(resp.Flag, want) {
+					t.Errorf("flag %.60q, want prefix %.60q", resp.Flag, want)
+				}
+			}
+			if got := flags.hitCount("/w320/no.png"); got != tt.wantHits {
+				t.Errorf("image fetched %d times, want %d", got, tt.wantHits)
+			}
+		})
+	}
+}
+
+// Images over FLAG_MAX_BYTES and fetches over FLAG_FETCH_TIMEOUT fail with
+// a clear error, and the info response falls back to the flag URL.
+func TestFlagFetchLimits(t *testing.T) {
+	const timeout = 100 * time.Millisecond
+	stall := func(r *http.Request) {
+		select {
+		case <-r.Context().Done():
+		case <-time.After(5 * timeout):
+		}
+	}
+	tests := []struct {
+		name     string
+		image    http.HandlerFunc
+		wantErr  string // "" for success
+		inlined  bool
+		maxBytes int64
+	}{
+		{
+			name:     "at the limit",
+			image:    func(w http.ResponseWriter, r *http.Request) { _, _ = w.Write(testFlagPNG) },
+			maxBytes: int64(len(testFlagPNG)),
+			inlined:  true,
+		},
+		{
+			name:     "over the limit",
+			image:    func(w http.ResponseWriter, r *http.Request) { _, _ = w.Write(testFlagPNG) },
+			maxBytes: int64(len(testFlagPNG)) - 1,
+			wantErr:  fmt.Sprintf("flag image exceeds %d bytes", len(testFlagPNG)-1),
+		},
+		{
+			name: "slow headers",
+			image: func(w http.ResponseWriter, r *http.Request) {
+				stall(r)
+			},
+			maxBytes: defaultFlagMaxBytes,
+			wantErr:  "flag fetch timed out after " + timeout.String(),
+		},
+		{
+			name: "slow body",
+			image: func(w http.ResponseWriter, r *http.Request) {
+				_, _ = w.Write(testFlagPNG[:8])
+				w.(http.Flusher).Flush()
+				stall(r)
+			},
+			maxBytes: defaultFlagMaxBytes,
+			wantErr:  "flag fetch timed out after " + timeout.String(),
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			flags := flagUpstreams(t, tt.image, func(cfg *Config) {
+				cfg.FlagMaxBytes = tt.maxBytes
+				cfg.FlagFetchTimeout = timeout
+			})
+			url := flags.URL + "/w320/no.png"
+
+			start := time.Now()
+			_, err := fetchFlagDataURI(context.Background(), url)
+			if elapsed := time.Since(start); elapsed > 3*timeout {
+				t.Errorf("fetch took %s with a %s timeout", elapsed, timeout)
+			}
+			switch {
+			case tt.wantErr == "" && err != nil:
+				t.Fatalf("unexpected error: %v", err)
+			case tt.wantErr != "" && (err == nil || err.Error() != tt.wantErr):
+				t.Fatalf("error %v, want %q", err, tt.wantErr)
+			}
+
+			var resp struct {
+				Flag string `json:"flag"`
+			}
+			getJSON(t, InfoHandler, "/countryinfo/v1/info/no?flagInline=true", http.StatusOK, &resp)
+			if got := strings.HasPrefix(resp.Flag, "data:"); got != tt.inlined {
+				t.Errorf("flag inlined %t, want %t (flag %.40q)", got, tt.inlined, resp.Flag)
+			}
+			if !tt.inlined && resp.Flag != url {
+				t.Errorf("flag %q, want the URL %q", resp.Flag, url)
+			}
+		})
+	}
+}
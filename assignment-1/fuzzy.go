@@ -0,0 +1,124 @@
+package main
+
+import (
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+/* -------------------- FUZZY name endpoint -------------------- */
+
+const (
+	defaultFuzzyMaxDistance = 2
+	maxFuzzyMaxDistance     = 10
+	defaultFuzzyLimit       = 10
+	maxFuzzyLimit           = 250
+)
+
+// FuzzyHandler serves /countryinfo/v1/fuzzy/{query}?maxDistance=2&limit=10&independentOnly=true.
+// Countries whose common name is within maxDistance edits of the query
+// (case-insensitive) are returned, closest first.
+func FuzzyHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		writeJSONError(w, http.StatusMethodNotAllowed, "method not allowed")
+		return
+	}
+	if !checkQueryParams(w, r, "maxDistance", "limit", "independentOnly") {
+		return
+	}
+
+	segs, ok := pathSegments(w, r, "/countryinfo/v1/fuzzy/", 1, 1, "/countryinfo/v1/fuzzy/{query}")
+	if !ok {
+		return
+	}
+	query := strings.ToLower(strings.TrimSpace(segs[0]))
+	if query == "" {
+		writeJSONError(w, http.StatusBadRequest, "query must not be empty, e.g. /countryinfo/v1/fuzzy/norwey")
+		return
+	}
+
+	q := r.URL.Query()
+	maxDistance := defaultFuzzyMaxDistance
+	if raw := q.Get("maxDistance"); raw != "" {
+		n, err := strconv.Atoi(raw)
+		if err != nil || n < 0 || n > maxFuzzyMaxDistance {
+			writeJSONError(w, http.StatusBadRequest, "maxDistance must be an integer between 0 and "+strconv.Itoa(maxFuzzyMaxDistance))
+			return
+		}
+		maxDistance = n
+	}
+	limit := defaultFuzzyLimit
+	if raw := q.Get("limit"); raw != "" {
+		n, err := strconv.Atoi(raw)
+		if err != nil || n < 1 || n > maxFuzzyLimit {
+			writeJSONError(w, http.StatusBadRequest, "limit must be an integer between 1 and "+strconv.Itoa(maxFuzzyLimit))
+			return
+		}
+		limit = n
+	}
+
+	all, st, err := getAllCountries(r.Context())
+	if err != nil {
+		writeUpstreamError(w, err, "failed to call countries service")
+		return
+	}
+	if st != http.StatusOK {
+		writeJSONError(w, http.StatusBadGateway, "countries service returned non-200")
+		return
+	}
+	if independentOnly(r) {
+		all = filterIndependent(all)
+	}
+
+	// Pointers into the cached dataset; never reorder the cache itself
+	type match struct {
+		c        *countriesCountry
+		distance int
+	}
+	var matches []match
+	for i := range all {
+		d := levenshtein(query, strings.ToLower(all[i].Name.Common))
+		if d <= maxDistance {
+			matches = append(matches, match{c: &all[i], distance: d})
+		}
+	}
+	sort.SliceStable(matches, func(i, j int) bool {
+		if matches[i].distance != matches[j].distance {
+			return matches[i].distance < matches[j].distance
+		}
+		return matches[i].c.Name.Common < matches[j].c.Name.Common
+	})
+
+	if limit < len(matches) {
+		matches = matches[:limit]
+	}
+	out := make([]infoResponse, 0, len(matches))
+	for _, m := range matches {
+		out = append(out, toInfoResponse(m.c))
+	}
+	writeJSON(w, http.StatusOK, out)
+}
+
+// levenshtein is the edit distance between a and b: the fewest single-rune
+// insertions, deletions and substitutions that turn one into the other.
+func levenshtein(a, b string) int {
+	ra, rb := []rune(a), []rune(b)
+	prev := make([]int, len(rb)+1)
+	cur := make([]int, len(rb)+1)
+	for j := range prev {
+		prev[j] = j
+	}
+	for i := 1; i <= len(ra); i++ {
+		cur[0] = i
+		for j := 1; j <= len(rb); j++ {
+			cost := 1
+			if ra[i-1] == rb[j-1] {
+				cost = 0
+			}
+			cur[j] = min(prev[j]+1, cur[j-1]+1, prev[j-1]+cost)
+		}
+		prev, cur = cur, prev
+	}
+	return prev[len(rb)]
+}
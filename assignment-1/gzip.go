@@ -0,0 +1,114 @@
+package main
+
+import (
+	"compress/gzip"
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+/* -------------------- GZIP compression -------------------- */
+
+const defaultGzipMinBytes = 1024
+
+// withGzip compresses responses for clients sending Accept-Encoding: gzip.
+// Bodies are held back until they reach Config.GzipMinBytes (GZIP_MIN_BYTES),
+// so small answers such as status go out uncompressed without the overhead.
+// Handlers set Content-Type before writing, and it is kept as is.
+func withGzip(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Add("Vary", "Accept-Encoding")
+		if r.Method == http.MethodHead || !acceptsGzip(r) {
+			next.ServeHTTP(w, r)
+			return
+		}
+		gw := &gzipWriter{ResponseWriter: w, status: http.StatusOK, min: LoadConfig().GzipMinBytes}
+		defer gw.finish()
+		next.ServeHTTP(gw, r)
+	})
+}
+
+// acceptsGzip reports whether Accept-Encoding lists gzip without q=0.
+func acceptsGzip(r *http.Request) bool {
+	for _, part := range strings.Split(r.Header.Get("Accept-Encoding"), ",") {
+		coding, params, _ := strings.Cut(part, ";")
+		if !strings.EqualFold(strings.TrimSpace(coding), "gzip") {
+			continue
+		}
+		if v, ok := strings.CutPrefix(strings.TrimSpace(params), "q="); ok {
+			if q, err := strconv.ParseFloat(v, 64); err == nil && q == 0 {
+				return false
+			}
+		}
+		return true
+	}
+	return false
+}
+
+// gzipWriter buffers the body until it reaches min bytes, then switches to
+// a gzip stream. The status is sent only once the encoding is decided.
+type gzipWriter struct {
+	http.ResponseWriter
+	status      int
+	min         int
+	buf         []byte
+	gz          *gzip.Writer
+	wroteHeader bool // WriteHeader was called by the handler
+	passthrough bool // the handler set its own Content-Encoding
+}
+
+func (w *gzipWriter) WriteHeader(status int) {
+	if w.wroteHeader {
+		return
+	}
+	w.wroteHeader = true
+	w.status = status
+	// Bodiless statuses and already-encoded bodies are left alone
+	if status < http.StatusOK || status == http.StatusNoContent || status == http.StatusNotModified ||
+		w.Header().Get("Content-Encoding") != "" {
+		w.passthrough = true
+		w.ResponseWriter.WriteHeader(status)
+	}
+}
+
+func (w *gzipWriter) Write(b []byte) (int, error) {
+	if !w.wroteHeader {
+		w.WriteHeader(http.StatusOK)
+	}
+	if w.passthrough {
+		return w.ResponseWriter.Write(b)
+	}
+	if w.gz != nil {
+		return w.gz.Write(b)
+	}
+	w.buf = append(w.buf, b...)
+	if len(w.buf) >= w.min {
+		w.startGzip()
+	}
+	return len(b), nil
+}
+
+func (w *gzipWriter) startGzip() {
+	h := w.Header()
+	h.Set("Content-Encoding", "gzip")
+	h.Del("Content-Length")
+	w.ResponseWriter.WriteHeader(w.status)
+	w.gz = gzip.NewWriter(w.ResponseWriter)
+	_, _ = w.gz.Write(w.buf)
+	w.buf = nil
+}
+
+// finish flushes whatever the handler left: the gzip trailer, or the short
+// body uncompressed.
+func (w *gzipWriter) finish() {
+	switch {
+	case w.passthrough:
+	case w.gz != nil:
+		_ = w.gz.Close()
+	default:
+		w.ResponseWriter.WriteHeader(w.status)
+		if len(w.buf) > 0 {
+			_, _ = w.ResponseWriter.Write(w.buf)
+		}
+	}
+}
@@ -1,27 +1,73 @@
 package main
 
 import (
+	"bytes"
+	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
+	"log"
+	"maps"
+	"math"
+	"net"
 	"net/http"
+	"slices"
 	"sort"
+	"strconv"
 	"strings"
+	"sync"
 	"time"
 )
 
 const (
-	version          = "v1"
-	countriesBaseURL = "http://129.241.150.113:8080/v3.1"
-	currencyBaseURL  = "http://129.241.150.113:9090/currency"
+	version                 = "v1"
+	defaultCountriesBaseURL = "http://129.241.150.113:8080/v3.1"
+	defaultCurrencyBaseURL  = "http://129.241.150.113:9090/currency"
+)
+
+// Set at build time, e.g.
+// go build -ldflags "-X main.buildVersion=1.4.0 -X main.gitCommit=$(git rev-parse --short HEAD)"
+var (
+	buildVersion = "dev"
+	gitCommit    = "unknown"
+)
+
+// Upstream base URLs, from COUNTRIES_BASE_URL and CURRENCY_BASE_URL
+func countriesBaseURL() string { return LoadConfig().CountriesBaseURL }
+func currencyBaseURL() string  { return LoadConfig().CurrencyBaseURL }
+
+const (
+	defaultDialTimeout         = 3 * time.Second
+	defaultTLSHandshakeTimeout = 3 * time.Second
 )
 
 var (
 	startTime  time.Time
-	httpClient = &http.Client{Timeout: 5 * time.Second}
+	httpClient = &http.Client{Timeout: defaultUpstreamTimeout} // Config.UpstreamTimeout, set by applyConfig
 )
 
+// newUpstreamTransport builds the transport shared by all upstream clients.
+// Connection-level timeouts make an unreachable host fail fast instead of
+// using up the whole client timeout.
+func newUpstreamTransport(cfg *Config) *http.Transport {
+	t := http.DefaultTransport.(*http.Transport).Clone()
+	t.DialContext = (&net.Dialer{
+		Timeout:   cfg.DialTimeout,
+		KeepAlive: 30 * time.Second,
+	}).DialContext
+	t.TLSHandshakeTimeout = cfg.TLSHandshakeTimeout
+	return t
+}
+
 type errResp struct {
 	Error string `json:"error"`
+	Stage string `json:"stage,omitempty"` // transport or decode, on upstream failures
+}
+
+// responseMeta is included in info/exchange responses with ?meta=true
+type responseMeta struct {
+	Sources       map[string]string `json:"sources,omitempty"` // exchange: currency -> "neighbour" or "watchlist"
+	UpstreamCalls int64             `json:"upstream_calls"`
 }
 
 func writeJSON(w http.ResponseWriter, status int, v any) {
@@ -34,16 +80,83 @@ func writeJSONError(w http.ResponseWriter, status int, msg string) {
 	writeJSON(w, status, errResp{Error: msg})
 }
 
+// checkQueryParams enforces a per-endpoint allowlist of query parameters when
+// STRICT_PARAMS is on, so typos like ?feilds= fail loudly instead of being
+// ignored. On rejection it writes the 400 itself and returns false.
+func checkQueryParams(w http.ResponseWriter, r *http.Request, allowed ...string) bool {
+	if !LoadConfig().StrictParams {
+		return true
+	}
+
+	var unknown []string
+	for key := range r.URL.Query() {
+		if !slices.Contains(allowed, key) {
+			unknown = append(unknown, key)
+		}
+	}
+	if len(unknown) == 0 {
+		return true
+	}
+
+	sort.Strings(unknown)
+	valid := "none"
+	if len(allowed) > 0 {
+		valid = strings.Join(allowed, ", ")
+	}
+	writeJSONError(w, http.StatusBadRequest, fmt.Sprintf("unknown query parameter(s): %s; valid: %s", strings.Join(unknown, ", "), valid))
+	return false
+}
+
+// uptimeSeconds is 0 when startTime was never set (handlers called without
+// main, e.g. in tests) rather than the time since year 1.
 func uptimeSeconds() int64 {
-	return int64(time.Since(startTime).Seconds())
+	if startTime.IsZero() {
+		return 0
+	}
+	return max(int64(time.Since(startTime).Seconds()), 0)
 }
 
+// normalizeISO2 lowercases and trims a country code. With LENIENT_CODES,
+// non-letters are stripped as well, so "n.o" becomes "no".
 func normalizeISO2(code string) string {
-	return strings.ToLower(strings.TrimSpace(code))
+	norm := strings.ToLower(strings.TrimSpace(code))
+	if !LoadConfig().LenientCodes {
+		return norm
+	}
+	letters := strings.Map(func(ch rune) rune {
+		if ch >= 'a' && ch <= 'z' {
+			return ch
+		}
+		return -1
+	}, norm)
+	if letters != norm {
+		log.Printf("coerced country code %q to %q", code, letters)
+	}
+	return letters
+}
+
+// requireCode normalizes the first path segment and answers 400 when there
+// is none or it is blank, so no upstream call is made for an empty code
+func requireCode(w http.ResponseWriter, segs []string, example string) (string, bool) {
+	code := ""
+	if len(segs) > 0 {
+		code = normalizeISO2(segs[0])
+	}
+	if code == "" {
+		writeJSONError(w, http.StatusBadRequest, "country code is required, e.g. "+example)
+		return "", false
+	}
+	return code, true
 }
 
 func validISO2(code string) bool {
-	if len(code) != 2 {
+	return len(code) == 2 && validISOAlpha(code)
+}
+
+// validISOAlpha accepts a lowercase alpha-2 or alpha-3 code ("no" or "nor");
+// the upstream /alpha/ path resolves both.
+func validISOAlpha(code string) bool {
+	if len(code) != 2 && len(code) != 3 {
 		return false
 	}
 	for _, ch := range code {
@@ -54,42 +167,193 @@ func validISO2(code string) bool {
 	return true
 }
 
+func validCurrencyCode(code string) bool {
+	if len(code) != 3 {
+		return false
+	}
+	for _, ch := range code {
+		if ch < 'A' || ch > 'Z' {
+			return false
+		}
+	}
+	return true
+}
+
+// parseCurrencyList parses a comma-separated list like "usd, EUR" into
+// uppercased codes. ok is false if any entry is not a 3-letter code.
+func parseCurrencyList(raw string) (codes []string, ok bool) {
+	for _, part := range strings.Split(raw, ",") {
+		part = strings.ToUpper(strings.TrimSpace(part))
+		if part == "" {
+			continue
+		}
+		if !validCurrencyCode(part) {
+			return nil, false
+		}
+		codes = append(codes, part)
+	}
+	return codes, true
+}
+
 /* -------------------- STATUS endpoint -------------------- */
 
 type statusResponse struct {
 	RestCountriesAPI any    `json:"restcountriesapi"`
 	CurrenciesAPI    any    `json:"currenciesapi"`
 	Version          string `json:"version"`
+	BuildVersion     string `json:"build_version"`
+	GitCommit        string `json:"git_commit"`
 	Uptime           int64  `json:"uptime"`
+
+	// Wall time of each upstream's probes; absent when it is not probed
+	RestCountriesMs float64 `json:"restcountries_ms,omitempty"`
+	CurrenciesMs    float64 `json:"currencies_ms,omitempty"`
+
+	// Only with STATUS_PROBE_SAMPLES > 1, so the default shape is unchanged
+	RestCountriesLatency *probeLatency `json:"restcountries_latency,omitempty"`
+	CurrenciesLatency    *probeLatency `json:"currencies_latency,omitempty"`
+
+	CurrencyBreaker string `json:"currency_breaker,omitempty"` // closed, open or half-open; absent when currency is not probed
+	GeneratedAt     string `json:"generated_at,omitempty"`     // only with ?withTimestamp=true or RESPONSE_TIMESTAMPS
 }
 
 func StatusHandler(w http.ResponseWriter, r *http.Request) {
-	if r.Method != http.MethodGet {
+	if r.Method != http.MethodGet && r.Method != http.MethodHead {
 		writeJSONError(w, http.StatusMethodNotAllowed, "method not allowed")
 		return
 	}
+	if !checkQueryParams(w, r, "withTimestamp") {
+		return
+	}
 
-	// Use lightweight “known-good” probes
-	restStatus := probeHTTP(fmt.Sprintf("%s/alpha/no", countriesBaseURL))
-	currStatus := probeHTTP(fmt.Sprintf("%s/NOK", currencyBaseURL))
+	cfg := LoadConfig()
+	resp, ok := probeUpstreams(r.Context(), cfg, cfg.StatusProbeSamples)
 
-	// Spec: 200 if everything OK, appropriate error otherwise.
+	// Spec: 200 if everything OK, appropriate error otherwise
 	overall := http.StatusOK
-	if restStatus != http.StatusOK || currStatus != http.StatusOK {
+	if !ok {
 		overall = http.StatusBadGateway
 	}
+	resp.GeneratedAt = generatedAt(r, nil) // probes are live, so this is now
+	writeNegotiated(w, r, overall, resp)
+}
 
+// probeUpstreams probes the upstreams the enabled endpoints depend on, each
+// with samples concurrent probes, and reports whether all answered 200.
+// Upstreams no enabled endpoint depends on are not probed and do not count.
+func probeUpstreams(ctx context.Context, cfg *Config, samples int) (statusResponse, bool) {
 	resp := statusResponse{
-		RestCountriesAPI: restStatus,
-		CurrenciesAPI:    currStatus,
+		RestCountriesAPI: upstreamDisabled,
+		CurrenciesAPI:    upstreamDisabled,
 		Version:          version,
+		BuildVersion:     buildVersion,
+		GitCommit:        gitCommit,
 		Uptime:           uptimeSeconds(),
 	}
-	writeJSON(w, overall, resp)
+	probeRest, probeCurrency := upstreamsInUse(cfg)
+
+	// Use lightweight “known-good” probes. Both upstreams are probed at
+	// once, so a slow one does not delay the other; each goroutine only
+	// writes its own fields.
+	var (
+		wg                 sync.WaitGroup
+		restOK, currencyOK = true, true
+	)
+	if probeRest {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			start := time.Now()
+			st, latency := probeSampled(ctx, probeRestCountries, samples)
+			resp.RestCountriesMs = millis(time.Since(start))
+			resp.RestCountriesAPI = st
+			if samples > 1 {
+				resp.RestCountriesLatency = &latency
+			}
+			restOK = st == http.StatusOK
+		}()
+	}
+	if probeCurrency {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			start := time.Now()
+			st, latency := probeSampled(ctx, probeCurrencyService, samples)
+			resp.CurrenciesMs = millis(time.Since(start))
+			resp.CurrenciesAPI = st
+			resp.CurrencyBreaker = currencyBreaker.current()
+			if samples > 1 {
+				resp.CurrenciesLatency = &latency
+			}
+			currencyOK = st == http.StatusOK
+		}()
+	}
+	wg.Wait()
+	return resp, restOK && currencyOK
+}
+
+// upstreamDisabled replaces a probe status when no enabled endpoint uses it.
+const upstreamDisabled = "disabled"
+
+// upstreamsInUse reports which upstreams the enabled endpoints depend on:
+// info needs REST Countries; exchange needs both.
+func upstreamsInUse(cfg *Config) (restCountries, currency bool) {
+	return cfg.EnableInfo || cfg.EnableExchange, cfg.EnableExchange
+}
+
+// Lightweight "known-good" resources on each upstream
+func restCountriesProbeURL() string { return countriesBaseURL() + "/alpha/no" }
+func currencyProbeURL() string      { return currencyBaseURL() + "/NOK" }
+
+func probeRestCountries(ctx context.Context) int {
+	return probeHTTP(ctx, restCountriesProbeURL())
+}
+
+// probeCurrencyService always uses GET: reachability is not enough, the
+// answer must hold at least one rate. A 200 without rates (or with a result
+// other than success) is reported as 502.
+func probeCurrencyService(ctx context.Context) int {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, currencyProbeURL(), nil)
+	if err != nil {
+		return http.StatusBadGateway
+	}
+	resp, err := doUpstreamRetry(httpClient, req)
+	if err != nil {
+		return http.StatusBadGateway
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return resp.StatusCode
+	}
+
+	var table upstreamCurrencyResponse
+	if err := json.NewDecoder(resp.Body).Decode(&table); err != nil ||
+		(table.Result != "" && table.Result != "success") || len(table.Rates) == 0 {
+		log.Printf("currency probe got 200 without usable rates")
+		return http.StatusBadGateway
+	}
+	return http.StatusOK
 }
 
-func probeHTTP(url string) int {
-	resp, err := httpClient.Get(url)
+// probeHTTP uses GET by default; HEAD (STATUS_PROBE_METHOD=HEAD) avoids
+// downloading a body on every status poll.
+func probeHTTP(ctx context.Context, url string) int {
+	if LoadConfig().StatusProbeMethod == http.MethodHead {
+		st := probeWithMethod(ctx, http.MethodHead, url)
+		// Not every upstream implements HEAD; fall back to GET for those
+		if st != http.StatusMethodNotAllowed && st != http.StatusNotImplemented {
+			return st
+		}
+	}
+	return probeWithMethod(ctx, http.MethodGet, url)
+}
+
+func probeWithMethod(ctx context.Context, method, url string) int {
+	req, err := http.NewRequestWithContext(ctx, method, url, nil)
+	if err != nil {
+		return http.StatusBadGateway
+	}
+	resp, err := doUpstreamRetry(httpClient, req)
 	if err != nil {
 		return http.StatusBadGateway
 	}
@@ -109,43 +373,169 @@ type countriesFlags struct {
 	SVG string `json:"svg"`
 }
 
+// postalCodeFormat describes the country's postal codes, e.g. "####"
+type postalCodeFormat struct {
+	Format string `json:"format"`
+	Regex  string `json:"regex"`
+}
+
+// International dialing: root "+4" and suffixes ["7"] make "+47"
+type countriesIdd struct {
+	Root     string   `json:"root"`
+	Suffixes []string `json:"suffixes"`
+}
+
 type countriesCountry struct {
-	Name       countriesName              `json:"name"`
-	Continents []string                   `json:"continents"`
-	Population int64                      `json:"population"`
-	Area       float64                    `json:"area"`
-	Languages  map[string]string          `json:"languages"`
-	Borders    []string                   `json:"borders"`
-	Flags      countriesFlags             `json:"flags"`
-	Capital    []string                   `json:"capital"`
-	Currencies map[string]json.RawMessage `json:"currencies"` // keys are currency codes
-}
-
-// /alpha/{code} can return an object or an array; support both
-func fetchCountryAlpha(code string) (*countriesCountry, int, error) {
-	url := fmt.Sprintf("%s/alpha/%s", countriesBaseURL, code)
-	resp, err := httpClient.Get(url)
+	Name        countriesName              `json:"name"`
+	CCA2        string                     `json:"cca2"`
+	CCA3        string                     `json:"cca3"`
+	Continents  []string                   `json:"continents"`
+	Region      string                     `json:"region"`
+	Subregion   string                     `json:"subregion"`
+	Population  int64                      `json:"population"`
+	Area        float64                    `json:"area"`
+	Languages   map[string]string          `json:"languages"`
+	Borders     []string                   `json:"borders"`
+	Flags       countriesFlags             `json:"flags"`
+	Capital     []string                   `json:"capital"`
+	Currencies  map[string]json.RawMessage `json:"currencies"` // keys are currency codes
+	Gini        map[string]float64         `json:"gini"`       // year -> value
+	Idd         countriesIdd               `json:"idd"`
+	Tld         []string                   `json:"tld"`
+	StartOfWeek string                     `json:"startOfWeek"` // monday, sunday or saturday
+	PostalCode  *postalCodeFormat          `json:"postalCode"`
+	Status      string                     `json:"status"` // ISO code status, e.g. officially-assigned or user-assigned
+	// Pointers so a missing field stays distinguishable from false
+	Independent *bool `json:"independent"`
+	UNMember    *bool `json:"unMember"`
+
+	// Filled by UnmarshalJSON, not by the upstream directly
+	CurrencyOrder []string        `json:"-"` // currency codes in upstream order, which a map loses
+	Present       map[string]bool `json:"-"` // upstream keys with a non-null value, to tell missing from zero
+
+	fetched time.Time // when the upstream sent it; kept through the cache
+}
+
+// errAmbiguousAlpha is returned in STRICT_ALPHA mode when an alpha lookup
+// matches more than one country.
+var errAmbiguousAlpha = errors.New("ambiguous upstream result")
+
+// /alpha/{code} can return an object or an array; support both.
+// With a non-empty etag the request is conditional; a 304 is returned as-is
+// (nil country) so the caller can keep its cached copy.
+func fetchCountryAlphaUpstream(ctx context.Context, code, etag string) (*countriesCountry, string, int, error) {
+	url := fmt.Sprintf("%s/alpha/%s", countriesBaseURL(), code)
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
 	if err != nil {
-		return nil, 0, err
+		return nil, "", 0, err
+	}
+	if etag != "" {
+		req.Header.Set("If-None-Match", etag)
+	}
+	resp, err := doUpstreamRetry(httpClient, req)
+	if err != nil {
+		return nil, "", 0, err
 	}
 	defer resp.Body.Close()
 
 	if resp.StatusCode != http.StatusOK {
-		return nil, resp.StatusCode, nil
+		return nil, "", resp.StatusCode, nil
 	}
 
 	var raw json.RawMessage
 	if err := json.NewDecoder(resp.Body).Decode(&raw); err != nil {
-		return nil, 0, err
+		return nil, "", 0, &upstreamDecodeError{service: "countries", err: err}
 	}
 
 	// Try array
 	var arr []countriesCountry
 	if err := json.Unmarshal(raw, &arr); err == nil && len(arr) > 0 {
-		return &arr[0], http.StatusOK, nil
+		if len(arr) > 1 {
+			// An alpha lookup should match exactly one country
+			codes := make([]string, len(arr))
+			for i, c := range arr {
+				codes[i] = c.CCA3
+			}
+			log.Printf("WARN alpha/%s returned %d countries %v", code, len(arr), codes)
+			if LoadConfig().StrictAlpha {
+				return nil, "", 0, errAmbiguousAlpha
+			}
+		}
+		arr[0].fetched = time.Now()
+		return &arr[0], resp.Header.Get("ETag"), http.StatusOK, nil
+	}
+
+	// Try a single object; one without a code or name is not a country
+	var obj countriesCountry
+	if err := json.Unmarshal(raw, &obj); err == nil && (obj.CCA3 != "" || obj.Name.Common != "") {
+		obj.fetched = time.Now()
+		return &obj, resp.Header.Get("ETag"), http.StatusOK, nil
 	}
 
-	return nil, 0, fmt.Errorf("unexpected alpha response shape")
+	return nil, "", 0, &upstreamDecodeError{service: "countries", err: errors.New("unexpected alpha response shape")}
+}
+
+// UnmarshalJSON decodes as usual and also records the order of the currency
+// keys, since many multi-currency countries list their primary one first.
+func (c *countriesCountry) UnmarshalJSON(b []byte) error {
+	type plain countriesCountry // no methods, so no recursion
+	if err := json.Unmarshal(b, (*plain)(c)); err != nil {
+		return err
+	}
+	var raw map[string]json.RawMessage
+	if err := json.Unmarshal(b, &raw); err != nil {
+		return err
+	}
+	c.CurrencyOrder = objectKeys(raw["currencies"])
+	c.Present = make(map[string]bool, len(raw))
+	for k, v := range raw {
+		if string(v) != "null" {
+			c.Present[k] = true
+		}
+	}
+	return nil
+}
+
+// objectKeys returns the keys of a JSON object in document order, or nil if
+// raw is not an object.
+func objectKeys(raw json.RawMessage) []string {
+	dec := json.NewDecoder(bytes.NewReader(raw))
+	if t, err := dec.Token(); err != nil || t != json.Delim('{') {
+		return nil
+	}
+	var keys []string
+	for dec.More() {
+		t, err := dec.Token()
+		if err != nil {
+			return keys
+		}
+		key, _ := t.(string)
+		var skip json.RawMessage
+		if err := dec.Decode(&skip); err != nil {
+			return keys
+		}
+		keys = append(keys, key)
+	}
+	return keys
+}
+
+const (
+	currencyPickSorted      = "sorted"
+	currencyPickFirstListed = "first-listed"
+)
+
+// primaryCurrency is the country's base currency, uppercased. By default the
+// alphabetically first code; with CURRENCY_PICK=first-listed the first one
+// in upstream order.
+func primaryCurrency(c *countriesCountry) string {
+	if LoadConfig().CurrencyPick == currencyPickFirstListed {
+		for _, k := range c.CurrencyOrder {
+			if _, ok := c.Currencies[k]; ok {
+				return strings.ToUpper(k)
+			}
+		}
+	}
+	return strings.ToUpper(firstCurrencyCodeSorted(c.Currencies))
 }
 
 func firstCurrencyCodeSorted(m map[string]json.RawMessage) string {
@@ -160,39 +550,105 @@ func firstCurrencyCodeSorted(m map[string]json.RawMessage) string {
 	return keys[0]
 }
 
+// currencyCodesSorted returns all valid (3-letter) currency codes, uppercased and sorted.
+func currencyCodesSorted(m map[string]json.RawMessage) []string {
+	codes := make([]string, 0, len(m))
+	for k := range m {
+		k = strings.ToUpper(strings.TrimSpace(k))
+		if len(k) == 3 {
+			codes = append(codes, k)
+		}
+	}
+	sort.Strings(codes)
+	return codes
+}
+
 /* -------------------- INFO endpoint -------------------- */
 
 type infoResponse struct {
-	Name       string            `json:"name"`
-	Continents []string          `json:"continents"`
-	Population int64             `json:"population"`
-	Area       float64           `json:"area"`
-	Languages  map[string]string `json:"languages"`
-	Borders    []string          `json:"borders"`
-	Flag       string            `json:"flag"`
-	Capital    string            `json:"capital"`
+	Name        string            `json:"name"`
+	CCA2        string            `json:"cca2"` // upstream case, e.g. NO
+	CCA3        string            `json:"cca3"`
+	Continents  []string          `json:"continents"`
+	Region      string            `json:"region"`
+	Subregion   string            `json:"subregion"` // empty for e.g. Antarctica
+	Population  int64             `json:"population"`
+	Area        float64           `json:"area"`
+	Languages   map[string]string `json:"languages"`
+	Borders     []string          `json:"borders"`
+	Flag        string            `json:"flag"`
+	Capital     string            `json:"capital"`
+	Independent *bool             `json:"independent"` // null when the upstream does not say
+	UNMember    *bool             `json:"un_member"`
+	Neighbours  []infoResponse    `json:"neighbours,omitempty"`      // only with ?depth=N
+	Extras      *infoExtras       `json:"extras,omitempty"`          // only with ?extras=true
+	AreaCompare *areaComparison   `json:"area_comparison,omitempty"` // only with ?compareTo=
+	Present     []string          `json:"_present,omitempty"`        // only with ?withPresence=true
+	Warning     string            `json:"warning,omitempty"`         // set when serving stale cached data
+	GeneratedAt string            `json:"generated_at,omitempty"`    // only with ?withTimestamp=true or RESPONSE_TIMESTAMPS
+	Spoken      *bool             `json:"language_spoken,omitempty"` // only with ?lang=
+	Meta        *responseMeta     `json:"meta,omitempty"`            // only with ?meta=true
 }
 
 func InfoHandler(w http.ResponseWriter, r *http.Request) {
-	if r.Method != http.MethodGet {
+	if r.Method != http.MethodGet && r.Method != http.MethodHead {
 		writeJSONError(w, http.StatusMethodNotAllowed, "method not allowed")
 		return
 	}
+	if !checkQueryParams(w, r, "depth", "flagInline", "meta", "extras", "compareTo", "withPresence", "withTimestamp", "lang") {
+		return
+	}
+	r, stale := withStaleMark(r)
+	r, clock := withFetchClock(r)
 
-	code := strings.TrimPrefix(r.URL.Path, "/countryinfo/v1/info/")
-	code = normalizeISO2(code)
+	segs, ok := pathSegments(w, r, "/countryinfo/v1/info/", 0, 1, "/countryinfo/v1/info/{country_code}")
+	if !ok {
+		return
+	}
+	code, ok := requireCode(w, segs, "/countryinfo/v1/info/no")
+	if !ok {
+		return
+	}
 
-	if !validISO2(code) {
-		writeJSONError(w, http.StatusBadRequest, "two_letter_country_code must be 2 letters (ISO 3166-2), e.g. /countryinfo/v1/info/no")
+	if !validISOAlpha(code) {
+		writeJSONError(w, http.StatusBadRequest, "country code must be 2 or 3 letters (ISO 3166-1 alpha-2 or alpha-3), e.g. /countryinfo/v1/info/no or /countryinfo/v1/info/nor")
 		return
 	}
+	// ISO 639-3 language key, e.g. ?lang=nor, as used in the languages map
+	lang := strings.ToLower(strings.TrimSpace(r.URL.Query().Get("lang")))
+	if r.URL.Query().Has("lang") && !validLanguageCode(lang) {
+		writeJSONError(w, http.StatusBadRequest, "lang must be a 3-letter ISO 639-3 language code, e.g. ?lang=nor")
+		return
+	}
+	compareTo := r.URL.Query().Get("compareTo")
+	if compareTo != "" {
+		compareTo = normalizeISO2(compareTo)
+		if !validISO2(compareTo) {
+			writeJSONError(w, http.StatusBadRequest, "compareTo must be a 2-letter country code, e.g. ?compareTo=fr")
+			return
+		}
+	}
+	depthRaw := r.URL.Query().Get("depth")
+	depth := 0
+	if depthRaw != "" {
+		var err error
+		depth, err = strconv.Atoi(depthRaw)
+		if err != nil || depth < 0 || depth > maxBorderDepth {
+			writeJSONError(w, http.StatusBadRequest, fmt.Sprintf("depth must be an integer between 0 and %d", maxBorderDepth))
+			return
+		}
+	}
 
-	c, st, err := fetchCountryAlpha(code)
+	c, st, err := fetchCountryAlpha(r.Context(), code)
+	if errors.Is(err, errAmbiguousAlpha) {
+		writeJSONError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
 	if err != nil {
-		writeJSONError(w, http.StatusBadGateway, "failed to call countries service")
+		writeUpstreamError(w, err, "failed to call countries service")
 		return
 	}
-	if st == http.StatusNotFound || c == nil {
+	if st == http.StatusNotFound || (st == http.StatusOK && c == nil) {
 		writeJSONError(w, http.StatusNotFound, "country not found")
 		return
 	}
@@ -201,6 +657,93 @@ func InfoHandler(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	out := toInfoResponse(c)
+	if lang != "" {
+		out.Languages, out.Spoken = filterLanguage(c.Languages, lang)
+	}
+
+	// Embed the flag image itself, for clients that render offline
+	if r.URL.Query().Get("flagInline") == "true" {
+		out.Flag = inlineFlag(r.Context(), c.Flags.PNG)
+		if out.Flag == "" {
+			out.Flag = c.Flags.SVG
+		}
+	}
+
+	if depthRaw != "" {
+		neighbours, err := expandBorders(r.Context(), c, depth)
+		if errors.Is(err, errBorderFetchCap) {
+			writeJSONError(w, http.StatusBadRequest, err.Error())
+			return
+		}
+		if err != nil {
+			writeJSONError(w, http.StatusBadGateway, err.Error())
+			return
+		}
+		out.Neighbours = neighbours
+	}
+
+	if r.URL.Query().Get("extras") == "true" {
+		out.Extras = toInfoExtras(c)
+	}
+
+	if r.URL.Query().Get("withPresence") == "true" {
+		out.Present = presentInfoFields(c)
+	}
+
+	if compareTo != "" {
+		ref, st, err := fetchCountryAlpha(r.Context(), compareTo)
+		if err != nil {
+			writeUpstreamError(w, err, "failed to call countries service")
+			return
+		}
+		if st == http.StatusNotFound || ref == nil {
+			writeJSONError(w, http.StatusBadRequest, "compareTo country not found")
+			return
+		}
+		if st != http.StatusOK {
+			writeJSONError(w, http.StatusBadGateway, "countries service returned non-200")
+			return
+		}
+		out.AreaCompare = compareArea(c, ref)
+	}
+
+	if r.URL.Query().Get("meta") == "true" {
+		out.Meta = &responseMeta{UpstreamCalls: upstreamCalls(r.Context())}
+	}
+	out.Warning = markStale(w, stale)
+	out.GeneratedAt = generatedAt(r, clock)
+
+	writeNegotiatedETag(w, r, http.StatusOK, out) // country data rarely changes; lets pollers get 304
+}
+
+func validLanguageCode(code string) bool {
+	if len(code) != 3 {
+		return false
+	}
+	for _, ch := range code {
+		if ch < 'a' || ch > 'z' {
+			return false
+		}
+	}
+	return true
+}
+
+// filterLanguage returns a new map holding only lang (matched
+// case-insensitively) and whether the country speaks it. The cached map
+// itself is left alone.
+func filterLanguage(languages map[string]string, lang string) (map[string]string, *bool) {
+	out := map[string]string{}
+	for k, v := range languages {
+		if strings.EqualFold(k, lang) {
+			out[k] = v
+		}
+	}
+	spoken := len(out) > 0
+	return out, &spoken
+}
+
+func toInfoResponse(c *countriesCountry) infoResponse {
 	capital := ""
 	if len(c.Capital) > 0 {
 		capital = c.Capital[0]
@@ -211,18 +754,22 @@ func InfoHandler(w http.ResponseWriter, r *http.Request) {
 		flag = c.Flags.SVG
 	}
 
-	out := infoResponse{
-		Name:       c.Name.Common,
-		Continents: c.Continents,
-		Population: c.Population,
-		Area:       c.Area,
-		Languages:  c.Languages,
-		Borders:    c.Borders,
-		Flag:       flag,
-		Capital:    capital,
+	return infoResponse{
+		Name:        c.Name.Common,
+		CCA2:        c.CCA2,
+		CCA3:        c.CCA3,
+		Continents:  c.Continents,
+		Region:      c.Region,
+		Subregion:   c.Subregion,
+		Population:  c.Population,
+		Area:        c.Area,
+		Languages:   c.Languages,
+		Borders:     c.Borders,
+		Flag:        flag,
+		Capital:     capital,
+		Independent: c.Independent,
+		UNMember:    c.UNMember,
 	}
-
-	writeJSON(w, http.StatusOK, out)
 }
 
 /* -------------------- CURRENCY models -------------------- */
@@ -230,11 +777,66 @@ func InfoHandler(w http.ResponseWriter, r *http.Request) {
 type upstreamCurrencyResponse struct {
 	Result string             `json:"result"`
 	Rates  map[string]float64 `json:"rates"`
+	Date   string             `json:"date"` // only from services that support historical rates
+
+	fetched time.Time // when the upstream sent it; kept through the cache
+	shape   string    // which response variant was decoded, for debug logging
 }
 
-func fetchRates(base string) (*upstreamCurrencyResponse, int, error) {
-	url := fmt.Sprintf("%s/%s", currencyBaseURL, base)
-	resp, err := httpClient.Get(url)
+// Currency-service response variants understood by UnmarshalJSON
+const (
+	ratesShapeResult  = "result"  // {"result": "success", "rates": {...}}, the course service
+	ratesShapeSuccess = "success" // {"success": true, "rates": {...}}
+	ratesShapeBase    = "base"    // {"base": "NOK", "rates": {...}} without a result
+	ratesShapeBare    = "bare"    // only "rates"
+)
+
+// UnmarshalJSON accepts every known response variant and normalizes it, so
+// handlers only ever check Result and Rates: a boolean success becomes
+// Result "success" or "error", and a variant without either leaves Result
+// empty, which handlers already accept.
+func (t *upstreamCurrencyResponse) UnmarshalJSON(b []byte) error {
+	var raw struct {
+		Result  string             `json:"result"`
+		Success *bool              `json:"success"`
+		Base    string             `json:"base"`
+		Rates   map[string]float64 `json:"rates"`
+		Date    string             `json:"date"`
+	}
+	if err := json.Unmarshal(b, &raw); err != nil {
+		return err
+	}
+
+	*t = upstreamCurrencyResponse{Result: raw.Result, Rates: raw.Rates, Date: raw.Date}
+	switch {
+	case raw.Result != "":
+		t.shape = ratesShapeResult
+	case raw.Success != nil:
+		t.shape = ratesShapeSuccess
+		t.Result = "success"
+		if !*raw.Success {
+			t.Result = "error"
+		}
+	case raw.Base != "":
+		t.shape = ratesShapeBase
+	default:
+		t.shape = ratesShapeBare
+	}
+	return nil
+}
+
+// fetchRatesUpstream fetches current rates, or historical rates when date
+// (YYYY-MM-DD) is set and the currency service supports it.
+func fetchRatesUpstream(ctx context.Context, base, date string) (*upstreamCurrencyResponse, int, error) {
+	url := fmt.Sprintf("%s/%s", currencyBaseURL(), base)
+	if date != "" {
+		url += "?date=" + date
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+	if err != nil {
+		return nil, 0, err
+	}
+	resp, err := doUpstreamRetry(httpClient, req)
 	if err != nil {
 		return nil, 0, err
 	}
@@ -246,40 +848,187 @@ func fetchRates(base string) (*upstreamCurrencyResponse, int, error) {
 
 	var out upstreamCurrencyResponse
 	if err := json.NewDecoder(resp.Body).Decode(&out); err != nil {
-		return nil, 0, err
+		return nil, 0, &upstreamDecodeError{service: "currency", err: err}
 	}
+	out.fetched = time.Now()
+	debugf("currency service answered %s with the %q response shape", base, out.shape)
 	return &out, http.StatusOK, nil
 }
 
 /* -------------------- EXCHANGE endpoint -------------------- */
 
 type exchangeResponse struct {
-	Country       string             `json:"country"`
-	BaseCurrency  string             `json:"base-currency"`
-	ExchangeRates map[string]float64 `json:"exchange-rates"`
+	Country       string                  `json:"country"`
+	BaseCurrency  string                  `json:"base-currency"`
+	ExchangeRates map[string]float64      `json:"exchange-rates"`
+	Reason        string                  `json:"reason,omitempty"` // why exchange-rates is empty, when it is not obvious
+	Date          string                  `json:"date,omitempty"`   // only with ?date=
+	Warning       string                  `json:"warning,omitempty"`
+	Details       []exchangeDetail        `json:"details,omitempty"`         // only with ?detailed=true
+	Neighbours    []exchangeNeighbour     `json:"neighbours,omitempty"`      // only with ?groupBy=country or ?verbose=true
+	Movements     map[string]rateMovement `json:"movements,omitempty"`       // only with ?classify=true
+	Flag          string                  `json:"flag,omitempty"`            // input country's PNG flag, only with ?withFlags=true
+	CurrencyUsage map[string]int          `json:"currency-usage,omitempty"`  // neighbours per currency, only with ?currencyUsage=true
+	Bases         []string                `json:"base-currencies,omitempty"` // bases that quoted a rate, only with ?bases=all
+	RateBases     map[string]string       `json:"rate-bases,omitempty"`      // currency -> base it is quoted against, only with ?bases=all
+	GeneratedAt   string                  `json:"generated_at,omitempty"`    // only with ?withTimestamp=true or RESPONSE_TIMESTAMPS
+	Meta          *responseMeta           `json:"meta,omitempty"`            // only with ?meta=true
+}
+
+// exchangeDetail describes one neighbour currency in detailed mode
+type exchangeDetail struct {
+	Currency   string   `json:"currency"`
+	Rate       float64  `json:"rate"`
+	Continents []string `json:"continents"`      // of the neighbour countries using this currency
+	Flags      []string `json:"flags,omitempty"` // their PNG flags, only with ?withFlags=true
 }
 
+// exchangeNeighbour is one bordering country with its currency and rate
+type exchangeNeighbour struct {
+	Name     string  `json:"name"`
+	CCA3     string  `json:"cca3"`
+	Currency string  `json:"currency"`
+	Rate     float64 `json:"rate,omitempty"` // absent in verbose mode when the currency got no rate
+	Flag     string  `json:"flag,omitempty"` // only with ?withFlags=true
+}
+
+const (
+	reasonAllNeighboursSameCurrency = "all_neighbours_same_currency"
+
+	sourceNeighbour = "neighbour"
+	sourceWatchlist = "watchlist"
+	sourceRegion    = "region"
+
+	basesPrimary = "primary"
+	basesAll     = "all"
+)
+
 func ExchangeHandler(w http.ResponseWriter, r *http.Request) {
-	if r.Method != http.MethodGet {
+	if r.Method != http.MethodGet && r.Method != http.MethodHead {
 		writeJSONError(w, http.StatusMethodNotAllowed, "method not allowed")
 		return
 	}
 
-	code := strings.TrimPrefix(r.URL.Path, "/countryinfo/v1/exchange/")
-	code = normalizeISO2(code)
+	const form = "/countryinfo/v1/exchange/{two_letter_country_code}[/full]"
+	segs, ok := pathSegments(w, r, "/countryinfo/v1/exchange/", 0, 2, form)
+	if !ok {
+		return
+	}
+	code, ok := requireCode(w, segs, "/countryinfo/v1/exchange/no")
+	if !ok {
+		return
+	}
+
+	// One deadline for the whole fan-out; calls still running when it passes
+	// fail, and the request is answered with 504
+	ctx, cancel := context.WithTimeout(r.Context(), LoadConfig().ExchangeDeadline)
+	defer cancel()
+	r = r.WithContext(ctx)
+
+	if len(segs) == 2 {
+		if segs[1] != "full" {
+			writeJSONError(w, http.StatusNotFound, "unknown resource, expected "+form)
+			return
+		}
+		exchangeFullHandler(w, r, code)
+		return
+	}
+	if !checkQueryParams(w, r, "include", "meta", "date", "detailed", "groupBy", "classify", "withFlags", "currencyUsage", "withTimestamp", "currencies", "bases", "base", "region", "round", "verbose") {
+		return
+	}
+	r, stale := withStaleMark(r)
+	r, clock := withFetchClock(r)
+	r = withAlphaMemo(r) // each code is looked up at most once per request
 
 	if !validISO2(code) {
 		writeJSONError(w, http.StatusBadRequest, "two_letter_country_code must be 2 letters (ISO 3166-2), e.g. /countryinfo/v1/exchange/no")
 		return
 	}
 
+	// Watchlist currencies to include regardless of neighbours, e.g. ?include=USD,EUR
+	include, ok := parseCurrencyList(r.URL.Query().Get("include"))
+	if !ok {
+		writeJSONError(w, http.StatusBadRequest, "include must be a comma-separated list of 3-letter currency codes, e.g. ?include=USD,EUR")
+		return
+	}
+	// Narrows the neighbour currencies, e.g. ?currencies=SEK,EUR; all when absent
+	only, ok := parseCurrencyList(r.URL.Query().Get("currencies"))
+	if !ok {
+		writeJSONError(w, http.StatusBadRequest, "currencies must be a comma-separated list of 3-letter currency codes, e.g. ?currencies=SEK,EUR")
+		return
+	}
+	withMeta := r.URL.Query().Get("meta") == "true"
+	detailed := r.URL.Query().Get("detailed") == "true"
+	verbose := r.URL.Query().Get("verbose") == "true" // every neighbour, not just those with a rate
+
+	// ?round=N rounds every rate to N decimals; full precision when absent
+	round := -1
+	if r.URL.Query().Has("round") {
+		round = defaultRoundDecimals
+		if raw := r.URL.Query().Get("round"); raw != "" {
+			n, err := strconv.Atoi(raw)
+			if err != nil || n < 0 || n > maxRoundDecimals {
+				writeJSONError(w, http.StatusBadRequest, fmt.Sprintf("round must be an integer between 0 and %d", maxRoundDecimals))
+				return
+			}
+			round = n
+		}
+	}
+
+	// ?region=europe widens the currencies from the neighbours to a region
+	region := strings.ToLower(strings.TrimSpace(r.URL.Query().Get("region")))
+	if region != "" && !slices.Contains(exchangeRegions, region) {
+		writeJSONError(w, http.StatusBadRequest, "region must be one of "+strings.Join(exchangeRegions, ", "))
+		return
+	}
+
+	// ?bases=all quotes against every currency the country uses, not just
+	// the primary one
+	bases := r.URL.Query().Get("bases")
+	if bases != "" && bases != basesPrimary && bases != basesAll {
+		writeJSONError(w, http.StatusBadRequest, "bases must be primary or all")
+		return
+	}
+
+	// ?base=USD quotes the neighbour currencies against a chosen currency
+	// instead of the country's own
+	override := strings.ToUpper(strings.TrimSpace(r.URL.Query().Get("base")))
+	if r.URL.Query().Has("base") && !validCurrencyCode(override) {
+		writeJSONError(w, http.StatusBadRequest, "base must be a 3-letter currency code, e.g. ?base=USD")
+		return
+	}
+	if override != "" && bases == basesAll {
+		writeJSONError(w, http.StatusBadRequest, "base cannot be combined with bases=all")
+		return
+	}
+
+	// ?groupBy=country adds a per-neighbour list; the currency map stays
+	groupBy := r.URL.Query().Get("groupBy")
+	if groupBy != "" && groupBy != "currency" && groupBy != "country" {
+		writeJSONError(w, http.StatusBadRequest, "groupBy must be currency or country")
+		return
+	}
+
+	// Historical rates, e.g. ?date=2024-01-01; current rates when absent
+	date := strings.TrimSpace(r.URL.Query().Get("date"))
+	if date != "" {
+		if _, err := time.Parse(time.DateOnly, date); err != nil {
+			writeJSONError(w, http.StatusBadRequest, "date must be formatted YYYY-MM-DD, e.g. ?date=2024-01-01")
+			return
+		}
+	}
+
 	// 1) Fetch input country
-	input, st, err := fetchCountryAlpha(code)
+	input, st, err := fetchCountryAlpha(r.Context(), code)
+	if errors.Is(err, errAmbiguousAlpha) {
+		writeJSONError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
 	if err != nil {
-		writeJSONError(w, http.StatusBadGateway, "failed to call countries service")
+		writeUpstreamError(w, err, "failed to call countries service")
 		return
 	}
-	if st == http.StatusNotFound || input == nil {
+	if st == http.StatusNotFound || (st == http.StatusOK && input == nil) {
 		writeJSONError(w, http.StatusNotFound, "country not found")
 		return
 	}
@@ -288,56 +1037,101 @@ func ExchangeHandler(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	// 2) Determine base currency (first currency key)
-	base := strings.ToUpper(firstCurrencyCodeSorted(input.Currencies))
+	// 2) Determine base currency (see primaryCurrency), unless overridden
+	base := override
+	if base == "" {
+		base = primaryCurrency(input)
+	}
 	if base == "" || len(base) != 3 {
 		writeJSONError(w, http.StatusBadGateway, "input country has no valid currency")
 		return
 	}
 
-	// 3) Collect neighbour currencies
-	neighCurrencies := make(map[string]struct{})
-	for _, cca3 := range input.Borders {
-		cca3 = strings.TrimSpace(cca3)
-		if cca3 == "" {
-			continue
-		}
-
-		nc, st2, err := fetchCountryAlpha(cca3) // alpha accepts cca3 too in most implementations
+	// 3) Collect neighbour currencies; lookups run concurrently and the
+	// first failure cancels the rest. With ?region= the region's countries
+	// stand in for the neighbours.
+	var users []*countriesCountry
+	userSource := sourceNeighbour
+	if region != "" {
+		userSource = sourceRegion
+		all, st, err := getAllCountries(r.Context())
 		if err != nil {
-			writeJSONError(w, http.StatusBadGateway, "failed to call countries service for neighbours")
+			writeUpstreamError(w, err, "failed to call countries service for region")
 			return
 		}
-		if st2 != http.StatusOK || nc == nil {
-			writeJSONError(w, http.StatusBadGateway, "countries service failed neighbour lookup")
+		if st != http.StatusOK {
+			writeJSONError(w, http.StatusBadGateway, "countries service returned non-200")
 			return
 		}
+		users = countriesInRegion(all, region, input.CCA3)
+	} else {
+		neighbours, err := fetchNeighbours(r.Context(), input)
+		if err != nil {
+			writeUpstreamError(w, err, err.Error())
+			return
+		}
+		users = neighbours
+	}
 
-		ccy := strings.ToUpper(firstCurrencyCodeSorted(nc.Currencies))
+	neighCurrencies := make(map[string][]*countriesCountry) // currency -> neighbours (or region countries) using it
+	sameAsBase := 0                                         // of those, skipped because they use the base currency
+	for _, nc := range users {
+		ccy := primaryCurrency(nc)
 		if ccy == "" || len(ccy) != 3 {
 			continue
 		}
 		if ccy == base {
+			sameAsBase++
 			continue
 		}
-		neighCurrencies[ccy] = struct{}{}
+		neighCurrencies[ccy] = append(neighCurrencies[ccy], nc)
+	}
+
+	// Neighbour currencies take precedence; the watchlist only adds new ones
+	sources := make(map[string]string, len(neighCurrencies)+len(include))
+	for ccy := range neighCurrencies {
+		if len(only) == 0 || slices.Contains(only, ccy) {
+			sources[ccy] = userSource
+		}
+	}
+	for _, ccy := range include {
+		if _, ok := sources[ccy]; !ok && ccy != base {
+			sources[ccy] = sourceWatchlist
+		}
 	}
 
 	// If no neighbours: return empty map (still 200)
-	if len(neighCurrencies) == 0 {
+	if len(sources) == 0 {
 		out := exchangeResponse{
 			Country:       input.Name.Common,
 			BaseCurrency:  base,
 			ExchangeRates: map[string]float64{},
 		}
-		writeJSON(w, http.StatusOK, out)
+		// Neighbours exist but all share the base (e.g. Eurozone interior);
+		// otherwise this looks the same as having no neighbours at all.
+		if sameAsBase > 0 && len(neighCurrencies) == 0 {
+			out.Reason = reasonAllNeighboursSameCurrency
+		}
+		if verbose {
+			out.Neighbours = exchangeVerboseNeighbours(users, base, out.ExchangeRates, r.URL.Query().Get("withFlags") == "true")
+		}
+		if withMeta {
+			out.Meta = &responseMeta{Sources: map[string]string{}, UpstreamCalls: upstreamCalls(r.Context())}
+		}
+		out.Warning = markStale(w, stale)
+		out.GeneratedAt = generatedAt(r, clock)
+		writeNegotiated(w, r, http.StatusOK, out)
 		return
 	}
 
 	// 4) Fetch rates once
-	ratesResp, st3, err := fetchRates(base)
+	ratesResp, st3, err := fetchRatesOn(r.Context(), base, date)
 	if err != nil {
-		writeJSONError(w, http.StatusBadGateway, "failed to call currency service")
+		writeRatesError(w, err)
+		return
+	}
+	if st3 != http.StatusOK && date != "" {
+		writeJSONError(w, http.StatusBadGateway, "currency service rejected date="+date+"; it may not support historical rates")
 		return
 	}
 	if st3 != http.StatusOK || ratesResp == nil {
@@ -348,12 +1142,19 @@ func ExchangeHandler(w http.ResponseWriter, r *http.Request) {
 		writeJSONError(w, http.StatusBadGateway, "currency service returned result != success")
 		return
 	}
+	// Lenient by default: an empty table just yields an empty exchange-rates map
+	if len(ratesResp.Rates) == 0 && LoadConfig().RequireBaseRates {
+		writeJSONError(w, http.StatusBadGateway, "base currency "+base+" is not supported by the currency service (no rates returned)")
+		return
+	}
 
-	// 5) Filter rates to neighbour currencies
+	// 5) Filter rates to neighbour (and watchlist) currencies
 	outRates := make(map[string]float64)
-	for ccy := range neighCurrencies {
+	outSources := make(map[string]string)
+	for ccy, src := range sources {
 		if v, ok := ratesResp.Rates[ccy]; ok {
 			outRates[ccy] = v
+			outSources[ccy] = src
 		}
 	}
 
@@ -362,5 +1163,224 @@ func ExchangeHandler(w http.ResponseWriter, r *http.Request) {
 		BaseCurrency:  base,
 		ExchangeRates: outRates,
 	}
-	writeJSON(w, http.StatusOK, out)
+	if date != "" {
+		out.Date = date
+		// A service without historical support typically just returns today's table
+		if !strings.HasPrefix(ratesResp.Date, date) {
+			out.Warning = "currency service did not confirm date=" + date + "; rates may be current"
+		}
+	}
+	// Snapshots are per base, so movements only cover the primary's rates
+	primaryRates := outRates
+	if bases == basesAll {
+		primaryRates = maps.Clone(outRates)
+		if err := mergeOtherBases(r.Context(), &out, input, base, date, sources, outSources); errors.Is(err, errCircuitOpen) {
+			writeRatesError(w, err)
+			return
+		} else if err != nil {
+			writeUpstreamError(w, err, err.Error())
+			return
+		}
+	}
+	if detailed {
+		out.Details = exchangeDetails(outRates, neighCurrencies)
+	}
+	// verbose is a superset of groupBy=country, so it wins when both are set
+	if verbose {
+		out.Neighbours = exchangeVerboseNeighbours(users, base, outRates, r.URL.Query().Get("withFlags") == "true")
+	} else if groupBy == "country" {
+		out.Neighbours = exchangeByCountry(outRates, neighCurrencies)
+	}
+	if r.URL.Query().Get("classify") == "true" {
+		out.Movements = classifyRates(base, primaryRates)
+	}
+	if r.URL.Query().Get("withFlags") == "true" {
+		addExchangeFlags(&out, input, neighCurrencies)
+	}
+	if r.URL.Query().Get("currencyUsage") == "true" {
+		// neighCurrencies keeps every neighbour, so shared currencies count each user
+		out.CurrencyUsage = make(map[string]int)
+		for ccy := range outRates {
+			if n := len(neighCurrencies[ccy]); n > 0 {
+				out.CurrencyUsage[ccy] = n
+			}
+		}
+	}
+	if round >= 0 {
+		roundExchangeRates(&out, round)
+	}
+	if withMeta {
+		out.Meta = &responseMeta{Sources: outSources, UpstreamCalls: upstreamCalls(r.Context())}
+	}
+	if warning := markStale(w, stale); warning != "" {
+		out.Warning = strings.TrimPrefix(out.Warning+"; "+warning, "; ")
+	}
+	out.GeneratedAt = generatedAt(r, clock)
+	writeNegotiated(w, r, http.StatusOK, out)
+}
+
+const (
+	defaultRoundDecimals = 4
+	maxRoundDecimals     = 8
+)
+
+// roundExchangeRates rounds every rate in out to decimals places, in the
+// rate map as well as in details and neighbours. The base currency never has
+// an entry of its own, so there is no implicit 1.0 to round. Movements keep
+// their full precision.
+func roundExchangeRates(out *exchangeResponse, decimals int) {
+	scale := math.Pow10(decimals)
+	roundRate := func(v float64) float64 { return math.Round(v*scale) / scale }
+
+	for ccy, v := range out.ExchangeRates {
+		out.ExchangeRates[ccy] = roundRate(v)
+	}
+	for i := range out.Details {
+		out.Details[i].Rate = roundRate(out.Details[i].Rate)
+	}
+	for i := range out.Neighbours {
+		out.Neighbours[i].Rate = roundRate(out.Neighbours[i].Rate)
+	}
+}
+
+// mergeOtherBases fills in rates the primary base's table lacks from the
+// country's other currencies, in sorted order, and records which base each
+// rate is quoted against. out.ExchangeRates must hold the primary's rates.
+func mergeOtherBases(ctx context.Context, out *exchangeResponse, input *countriesCountry, primary, date string, sources, outSources map[string]string) error {
+	out.Bases = []string{primary}
+	out.RateBases = make(map[string]string, len(sources))
+	for ccy := range out.ExchangeRates {
+		out.RateBases[ccy] = primary
+	}
+
+	for _, base := range currencyCodesSorted(input.Currencies) {
+		if base == primary {
+			continue
+		}
+		table, st, err := fetchRatesOn(ctx, base, date)
+		if errors.Is(err, errCircuitOpen) {
+			return err
+		}
+		if err != nil {
+			return upstreamFailure(err, "failed to call currency service")
+		}
+		if st != http.StatusOK || table == nil || (table.Result != "" && table.Result != "success") {
+			return fmt.Errorf("currency service returned no rates for base currency %s", base)
+		}
+
+		contributed := false
+		for ccy, src := range sources {
+			if _, done := out.ExchangeRates[ccy]; done || ccy == base {
+				continue
+			}
+			if v, ok := table.Rates[ccy]; ok {
+				out.ExchangeRates[ccy] = v
+				out.RateBases[ccy] = base
+				outSources[ccy] = src
+				contributed = true
+			}
+		}
+		if contributed {
+			out.Bases = append(out.Bases, base)
+		}
+	}
+	return nil
+}
+
+// exchangeDetails builds one entry per returned neighbour currency, reusing
+// the neighbour countries already fetched. Watchlist-only currencies have no
+// neighbour and are left out.
+func exchangeDetails(rates map[string]float64, users map[string][]*countriesCountry) []exchangeDetail {
+	out := make([]exchangeDetail, 0, len(users))
+	for ccy, countries := range users {
+		rate, ok := rates[ccy]
+		if !ok {
+			continue
+		}
+		seen := make(map[string]bool)
+		continents := []string{}
+		for _, c := range countries {
+			for _, cont := range c.Continents {
+				if !seen[cont] {
+					seen[cont] = true
+					continents = append(continents, cont)
+				}
+			}
+		}
+		sort.Strings(continents)
+		out = append(out, exchangeDetail{Currency: ccy, Rate: rate, Continents: continents})
+	}
+	sort.Slice(out, func(i, j int) bool { return out[i].Currency < out[j].Currency })
+	return out
+}
+
+// addExchangeFlags fills in PNG flags from the countries already fetched, so
+// it costs no extra upstream calls.
+func addExchangeFlags(out *exchangeResponse, input *countriesCountry, users map[string][]*countriesCountry) {
+	out.Flag = input.Flags.PNG
+
+	for i := range out.Details {
+		d := &out.Details[i]
+		countries := slices.Clone(users[d.Currency])
+		sort.Slice(countries, func(a, b int) bool { return countries[a].Name.Common < countries[b].Name.Common })
+		for _, c := range countries {
+			if c.Flags.PNG != "" {
+				d.Flags = append(d.Flags, c.Flags.PNG)
+			}
+		}
+	}
+
+	flagByCCA3 := make(map[string]string)
+	for _, countries := range users {
+		for _, c := range countries {
+			flagByCCA3[c.CCA3] = c.Flags.PNG
+		}
+	}
+	for i := range out.Neighbours {
+		if f, ok := flagByCCA3[out.Neighbours[i].CCA3]; ok {
+			out.Neighbours[i].Flag = f
+		}
+	}
+}
+
+// exchangeByCountry lists each neighbour whose currency got a rate, sorted
+// by name. Neighbours sharing the base currency are not in users.
+func exchangeByCountry(rates map[string]float64, users map[string][]*countriesCountry) []exchangeNeighbour {
+	out := []exchangeNeighbour{}
+	for ccy, countries := range users {
+		rate, ok := rates[ccy]
+		if !ok {
+			continue
+		}
+		for _, c := range countries {
+			out = append(out, exchangeNeighbour{Name: c.Name.Common, CCA3: c.CCA3, Currency: ccy, Rate: rate})
+		}
+	}
+	sort.Slice(out, func(i, j int) bool { return out[i].Name < out[j].Name })
+	return out
+}
+
+// exchangeVerboseNeighbours lists every neighbour (or region country) with
+// its primary currency, in border order. Unlike exchangeByCountry it keeps
+// neighbours sharing the base, quoted at 1, and those whose currency got no
+// rate, without one. Flags are set here because addExchangeFlags only knows
+// the neighbours with another currency.
+func exchangeVerboseNeighbours(users []*countriesCountry, base string, rates map[string]float64, withFlags bool) []exchangeNeighbour {
+	out := make([]exchangeNeighbour, 0, len(users))
+	for _, c := range users {
+		if c == nil {
+			continue
+		}
+		n := exchangeNeighbour{Name: c.Name.Common, CCA3: c.CCA3, Currency: primaryCurrency(c)}
+		if n.Currency == base {
+			n.Rate = 1
+		} else {
+			n.Rate = rates[n.Currency]
+		}
+		if withFlags {
+			n.Flag = c.Flags.PNG
+		}
+		out = append(out, n)
+	}
+	return out
 }
@@ -0,0 +1,395 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"net/http"
+	"os"
+	"path/filepath"
+	"reflect"
+	"slices"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+var update = flag.Bool("update", false, "rewrite the golden files in testdata/golden")
+
+// volatileFields vary between runs (timings), so they are zeroed before a
+// response is compared with its golden file. Their names are still pinned.
+var volatileFields = []string{"uptime", "restcountries_ms", "currencies_ms"}
+
+// The info, exchange and status responses keep the field names and shapes
+// the assignment spec requires, e.g. base-currency and exchange-rates.
+// Run with -update to accept a deliberate change.
+func TestResponsesMatchGoldenFiles(t *testing.T) {
+	tests := []struct {
+		golden  string
+		handler http.HandlerFunc
+		target  string
+	}{
+		{"info_no.json", InfoHandler, "/countryinfo/v1/info/no"},
+		{"exchange_no.json", ExchangeHandler, "/countryinfo/v1/exchange/no"},
+		{"status.json", StatusHandler, "/countryinfo/v1/status/"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.golden, func(t *testing.T) {
+			nordicUpstreams(t, nil)
+
+			var body map[string]any
+			getJSON(t, tt.handler, tt.target, http.StatusOK, &body)
+			for _, k := range volatileFields {
+				if _, ok := body[k]; ok {
+					body[k] = 0
+				}
+			}
+			got, err := json.MarshalIndent(body, "", "  ") // map keys come out sorted
+			if err != nil {
+				t.Fatal(err)
+			}
+			got = append(got, '\n')
+
+			path := filepath.Join("testdata", "golden", tt.golden)
+			if *update {
+				if err := os.WriteFile(path, got, 0o644); err != nil {
+					t.Fatal(err)
+				}
+			}
+			want, err := os.ReadFile(path)
+			if err != nil {
+				t.Fatalf("%v (run go test -update to create it)", err)
+			}
+			if !bytes.Equal(bytes.ReplaceAll(want, []byte("\r\n"), []byte("\n")), got) {
+				t.Errorf("%s differs from the golden file\ngot:\n%s\nwant:\n%s", tt.target, got, want)
+			}
+		})
+	}
+}
+
+// With STATUS_PROBE_METHOD=HEAD, probes fall back to GET when the upstream
+// does not implement HEAD; with the default GET they never send HEAD.
+func TestProbeHTTPMethod(t *testing.T) {
+	tests := []struct {
+		name        string
+		probeMethod string
+		headStatus  int // the upstream's answer to HEAD
+		wantMethods []string
+		wantStatus  int
+	}{
+		{"GET default", http.MethodGet, http.StatusOK, []string{"GET"}, http.StatusOK},
+		{"HEAD supported", http.MethodHead, http.StatusOK, []string{"HEAD"}, http.StatusOK},
+		{"HEAD rejected with 405", http.MethodHead, http.StatusMethodNotAllowed, []string{"HEAD", "GET"}, http.StatusOK},
+		{"HEAD not implemented", http.MethodHead, http.StatusNotImplemented, []string{"HEAD", "GET"}, http.StatusOK},
+		{"HEAD fails otherwise", http.MethodHead, http.StatusServiceUnavailable, []string{"HEAD"}, http.StatusServiceUnavailable},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var mu sync.Mutex
+			var methods []string
+			upstream := newUpstreamStub(t, func(w http.ResponseWriter, r *http.Request) {
+				mu.Lock()
+				methods = append(methods, r.Method)
+				mu.Unlock()
+				if r.Method == http.MethodHead {
+					w.WriteHeader(tt.headStatus)
+					return
+				}
+				_, _ = w.Write([]byte(`[]`))
+			})
+			useUpstreams(t, upstream, nil, func(cfg *Config) {
+				cfg.StatusProbeMethod = tt.probeMethod
+			})
+
+			ctx := withSingleAttempt(context.Background())
+			if st := probeHTTP(ctx, upstream.URL+"/all"); st != tt.wantStatus {
+				t.Errorf("status %d, want %d", st, tt.wantStatus)
+			}
+			if !slices.Equal(methods, tt.wantMethods) {
+				t.Errorf("methods %v, want %v", methods, tt.wantMethods)
+			}
+		})
+	}
+}
+
+// Uptime is 0 rather than nonsense when main never set startTime (as in
+// these tests) or the clock went backwards.
+func TestStatusUptime(t *testing.T) {
+	tests := []struct {
+		name      string
+		startTime time.Time
+		want      int64
+	}{
+		{"never set", time.Time{}, 0},
+		{"started", time.Now().Add(-90 * time.Second), 90},
+		{"in the future", time.Now().Add(time.Hour), 0},
+	}
+	saved := startTime
+	t.Cleanup(func() { startTime = saved })
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			nordicUpstreams(t, nil)
+			startTime = tt.startTime
+
+			var resp struct {
+				Uptime int64 `json:"uptime"`
+			}
+			getJSON(t, StatusHandler, "/countryinfo/v1/status/", http.StatusOK, &resp)
+			if resp.Uptime < tt.want || resp.Uptime > tt.want+1 {
+				t.Errorf("uptime %d, want %d", resp.Uptime, tt.want)
+			}
+		})
+	}
+}
+
+// REQUIRE_BASE_RATES turns an empty rate table for the base currency into a
+// 502; by default the exchange is answered with no rates.
+func TestExchangeRequireBaseRates(t *testing.T) {
+	tests := []struct {
+		name       string
+		require    bool
+		nokRates   map[string]float64
+		wantStatus int
+		wantRates  int
+	}{
+		{"lenient, unsupported base", false, map[string]float64{}, http.StatusOK, 0},
+		{"strict, unsupported base", true, map[string]float64{}, http.StatusBadGateway, 0},
+		{"strict, supported base", true, nordicRates["NOK"], http.StatusOK, 3},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			countries := newUpstreamStub(t, countriesStubHandler(t, nordicFixtures...))
+			currency := newUpstreamStub(t, currencyStubHandler(map[string]map[string]float64{"NOK": tt.nokRates}))
+			useUpstreams(t, countries, currency, func(cfg *Config) {
+				cfg.RequireBaseRates = tt.require
+			})
+
+			var resp struct {
+				Rates map[string]float64 `json:"exchange-rates"`
+				Error string             `json:"error"`
+			}
+			getJSON(t, ExchangeHandler, "/countryinfo/v1/exchange/no", tt.wantStatus, &resp)
+			if len(resp.Rates) != tt.wantRates {
+				t.Errorf("%d rates, want %d: %v", len(resp.Rates), tt.wantRates, resp.Rates)
+			}
+			if tt.wantStatus != http.StatusOK && !strings.Contains(resp.Error, "NOK is not supported") {
+				t.Errorf("error %q does not name the unsupported base", resp.Error)
+			}
+		})
+	}
+}
+
+// A non-200 from /all for ?region= is reported as such, not as a transport
+// failure.
+func TestExchangeRegionUpstreamNon200(t *testing.T) {
+	alpha := countriesStubHandler(t, nordicFixtures...)
+	countries := newUpstreamStub(t, func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/all" {
+			w.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+		alpha(w, r)
+	})
+	currency := newUpstreamStub(t, currencyStubHandler(nordicRates))
+	useUpstreams(t, countries, currency, nil)
+
+	var resp errResp
+	getJSON(t, ExchangeHandler, "/countryinfo/v1/exchange/no?region=europe", http.StatusBadGateway, &resp)
+	if want := (errResp{Error: "countries service returned non-200"}); resp != want {
+		t.Errorf("got %+v, want %+v", resp, want)
+	}
+}
+
+// An out-of-range ?depth= is rejected before the country is looked up.
+func TestInfoRejectsDepthBeforeFetch(t *testing.T) {
+	countries, _ := nordicUpstreams(t, nil)
+	for _, raw := range []string{"-1", "x", fmt.Sprint(maxBorderDepth + 1)} {
+		getJSON(t, InfoHandler, "/countryinfo/v1/info/no?depth="+raw, http.StatusBadRequest, nil)
+	}
+	if got := countries.hitCount("/alpha/no"); got != 0 {
+		t.Errorf("countries service called %d times for invalid depths", got)
+	}
+}
+
+// With LENIENT_CODES, non-letters are stripped from the code before it is
+// validated; by default a messy code is rejected.
+func TestLenientCodes(t *testing.T) {
+	tests := []struct {
+		code       string // escaped path segment
+		lenient    bool
+		wantStatus int
+	}{
+		{"no", false, http.StatusOK},
+		{"no.", false, http.StatusBadRequest},
+		{"no.", true, http.StatusOK},
+		{"n.o", false, http.StatusBadRequest},
+		{"n.o", true, http.StatusOK},
+		{"N-O", true, http.StatusOK},
+		{"%20no%20", true, http.StatusOK},
+		{"n0o", true, http.StatusOK},
+		{"nor!", true, http.StatusOK},
+		{"n.o.r.w", true, http.StatusBadRequest}, // four letters stay invalid
+		{"...", true, http.StatusBadRequest},
+	}
+	for _, tt := range tests {
+		t.Run(fmt.Sprintf("%s lenient=%t", tt.code, tt.lenient), func(t *testing.T) {
+			nordicUpstreams(t, func(cfg *Config) {
+				cfg.LenientCodes = tt.lenient
+			})
+
+			var resp struct {
+				Name string `json:"name"`
+			}
+			getJSON(t, InfoHandler, "/countryinfo/v1/info/"+tt.code, tt.wantStatus, &resp)
+			if tt.wantStatus == http.StatusOK && resp.Name != "Norway" {
+				t.Errorf("name %q, want Norway", resp.Name)
+			}
+		})
+	}
+}
+
+// The currency probe needs a usable rate table: a 200 without one reports
+// the currency service as degraded.
+func TestStatusCurrencyProbeBody(t *testing.T) {
+	tests := []struct {
+		name         string
+		status       int
+		body         string
+		wantCurrency float64
+		wantStatus   int
+	}{
+		{"rates", http.StatusOK, `{"result":"success","base_code":"NOK","rates":{"NOK":1,"SEK":0.98}}`, 200, http.StatusOK},
+		{"rates without result", http.StatusOK, `{"base":"NOK","rates":{"SEK":0.98}}`, 200, http.StatusOK},
+		{"empty body", http.StatusOK, ``, 502, http.StatusBadGateway},
+		{"empty object", http.StatusOK, `{}`, 502, http.StatusBadGateway},
+		{"empty rates", http.StatusOK, `{"result":"success","rates":{}}`, 502, http.StatusBadGateway},
+		{"error result", http.StatusOK, `{"result":"error","error-type":"unsupported-code"}`, 502, http.StatusBadGateway},
+		{"not json", http.StatusOK, `<html>ok</html>`, 502, http.StatusBadGateway},
+		{"unavailable", http.StatusServiceUnavailable, ``, 503, http.StatusBadGateway},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			countries := newUpstreamStub(t, countriesStubHandler(t, nordicFixtures...))
+			currency := newUpstreamStub(t, func(w http.ResponseWriter, r *http.Request) {
+				w.WriteHeader(tt.status)
+				_, _ = w.Write([]byte(tt.body))
+			})
+			useUpstreams(t, countries, currency, nil)
+
+			var resp struct {
+				RestCountries any `json:"restcountriesapi"`
+				Currencies    any `json:"currenciesapi"`
+			}
+			getJSON(t, StatusHandler, "/countryinfo/v1/status/", tt.wantStatus, &resp)
+			if resp.Currencies != tt.wantCurrency {
+				t.Errorf("currenciesapi %v, want %v", resp.Currencies, tt.wantCurrency)
+			}
+			if resp.RestCountries != float64(200) {
+				t.Errorf("restcountriesapi %v, want 200", resp.RestCountries)
+			}
+		})
+	}
+}
+
+// Every known currency-service response shape decodes to the same Result
+// and Rates, and the exchange endpoint works against each of them.
+func TestCurrencyResponseShapes(t *testing.T) {
+	rates := map[string]float64{"SEK": 0.98, "EUR": 0.085, "RUB": 8.1}
+	tests := []struct {
+		name       string
+		body       string
+		wantShape  string
+		wantResult string
+		wantStatus int // of the exchange endpoint
+	}{
+		{"result", `{"result":"success","base_code":"NOK","rates":{"SEK":0.98,"EUR":0.085,"RUB":8.1}}`, ratesShapeResult, "success", http.StatusOK},
+		{"success true", `{"success":true,"base":"NOK","rates":{"SEK":0.98,"EUR":0.085,"RUB":8.1}}`, ratesShapeSuccess, "success", http.StatusOK},
+		{"success false", `{"success":false,"rates":{"SEK":0.98,"EUR":0.085,"RUB":8.1}}`, ratesShapeSuccess, "error", http.StatusBadGateway},
+		{"base", `{"base":"NOK","date":"2024-01-02","rates":{"SEK":0.98,"EUR":0.085,"RUB":8.1}}`, ratesShapeBase, "", http.StatusOK},
+		{"bare", `{"rates":{"SEK":0.98,"EUR":0.085,"RUB":8.1}}`, ratesShapeBare, "", http.StatusOK},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var table upstreamCurrencyResponse
+			if err := json.Unmarshal([]byte(tt.body), &table); err != nil {
+				t.Fatalf("decode: %v", err)
+			}
+			if table.shape != tt.wantShape || table.Result != tt.wantResult {
+				t.Errorf("shape %q, result %q; want %q, %q", table.shape, table.Result, tt.wantShape, tt.wantResult)
+			}
+			if !reflect.DeepEqual(table.Rates, rates) {
+				t.Errorf("rates %v, want %v", table.Rates, rates)
+			}
+
+			countries := newUpstreamStub(t, countriesStubHandler(t, nordicFixtures...))
+			currency := newUpstreamStub(t, func(w http.ResponseWriter, r *http.Request) {
+				_, _ = w.Write([]byte(tt.body))
+			})
+			useUpstreams(t, countries, currency, nil)
+
+			var resp struct {
+				Rates map[string]float64 `json:"exchange-rates"`
+			}
+			getJSON(t, ExchangeHandler, "/countryinfo/v1/exchange/no", tt.wantStatus, &resp)
+			if tt.wantStatus == http.StatusOK && !reflect.DeepEqual(resp.Rates, rates) {
+				t.Errorf("exchange-rates %v, want %v", resp.Rates, rates)
+			}
+		})
+	}
+}
+
+// The alpha endpoint may answer with an array or a single object; anything
+// else is a decode error rather than a country.
+func TestFetchCountryAlphaShapes(t *testing.T) {
+	tests := []struct {
+		name     string
+		body     string
+		strict   bool
+		wantName string // "" when an error is expected
+		wantErr  error  // nil for any *upstreamDecodeError
+	}{
+		{"array", "[" + fixtureNorway + "]", false, "Norway", nil},
+		{"object", fixtureNorway, false, "Norway", nil},
+		{"object with name only", `{"name":{"common":"Norway"}}`, false, "Norway", nil},
+		{"several matches", "[" + fixtureNorway + "," + fixtureSweden + "]", false, "Norway", nil},
+		{"several matches, strict", "[" + fixtureNorway + "," + fixtureSweden + "]", true, "", errAmbiguousAlpha},
+		{"empty array", `[]`, false, "", nil},
+		{"object without code or name", `{"status":404,"message":"Not Found"}`, false, "", nil},
+		{"string", `"Norway"`, false, "", nil},
+		{"not json", `<html></html>`, false, "", nil},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			countries := newUpstreamStub(t, func(w http.ResponseWriter, r *http.Request) {
+				_, _ = w.Write([]byte(tt.body))
+			})
+			useUpstreams(t, countries, nil, func(cfg *Config) {
+				cfg.StrictAlpha = tt.strict
+			})
+
+			c, _, st, err := fetchCountryAlphaUpstream(context.Background(), "no", "")
+			if tt.wantName != "" {
+				if err != nil || st != http.StatusOK || c == nil || c.Name.Common != tt.wantName {
+					t.Fatalf("got %v, %d, %v; want %s", c, st, err, tt.wantName)
+				}
+				if c.fetched.IsZero() {
+					t.Error("fetched time not set")
+				}
+				return
+			}
+			var decodeErr *upstreamDecodeError
+			switch {
+			case c != nil:
+				t.Errorf("country %q, want none", c.Name.Common)
+			case tt.wantErr != nil && !errors.Is(err, tt.wantErr):
+				t.Errorf("err %v, want %v", err, tt.wantErr)
+			case tt.wantErr == nil && !errors.As(err, &decodeErr):
+				t.Errorf("err %v (%T), want an upstreamDecodeError", err, err)
+			}
+		})
+	}
+}
@@ -0,0 +1,55 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"time"
+)
+
+/* -------------------- HEALTH and READY endpoints -------------------- */
+
+// Orchestrators poll these often and with short timeouts of their own, so
+// readiness gets one probe per upstream and its own tight deadline instead
+// of the status endpoint's sampling and statusProbeDeadline.
+const readyProbeDeadline = 3 * time.Second
+
+type healthResponse struct {
+	Status string `json:"status"`
+	Uptime int64  `json:"uptime"`
+}
+
+type readyResponse struct {
+	Ready            bool `json:"ready"`
+	RestCountriesAPI any  `json:"restcountriesapi"`
+	CurrenciesAPI    any  `json:"currenciesapi"`
+}
+
+// HealthHandler serves /health (liveness): 200 as long as the process can
+// answer. It never calls an upstream, so an upstream outage does not get the
+// service restarted.
+func HealthHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet && r.Method != http.MethodHead {
+		writeJSONError(w, http.StatusMethodNotAllowed, "method not allowed")
+		return
+	}
+	writeNegotiated(w, r, http.StatusOK, healthResponse{Status: "ok", Uptime: uptimeSeconds()})
+}
+
+// ReadyHandler serves /ready (readiness): 200 when every upstream the
+// enabled endpoints depend on answers its probe, 503 otherwise.
+func ReadyHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet && r.Method != http.MethodHead {
+		writeJSONError(w, http.StatusMethodNotAllowed, "method not allowed")
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(r.Context(), readyProbeDeadline)
+	defer cancel()
+	st, ok := probeUpstreams(ctx, LoadConfig(), 1)
+
+	status := http.StatusOK
+	if !ok {
+		status = http.StatusServiceUnavailable
+	}
+	writeNegotiated(w, r, status, readyResponse{Ready: ok, RestCountriesAPI: st.RestCountriesAPI, CurrenciesAPI: st.CurrenciesAPI})
+}
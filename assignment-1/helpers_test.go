@@ -0,0 +1,247 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+/* -------------------- Upstream fixtures -------------------- */
+
+// Trimmed REST Countries objects, in the upstream's shape
+const (
+	fixtureNorway = `{"name":{"common":"Norway"},"cca2":"NO","cca3":"NOR","continents":["Europe"],"region":"Europe","subregion":"Northern Europe",` +
+		`"population":5379475,"area":323802,"languages":{"nno":"Norwegian Nynorsk","nob":"Norwegian Bokmål","smi":"Sami"},` +
+		`"borders":["FIN","SWE","RUS"],"flags":{"png":"https://flagcdn.com/w320/no.png","svg":"https://flagcdn.com/no.svg"},` +
+		`"capital":["Oslo"],"currencies":{"NOK":{"name":"Norwegian krone","symbol":"kr"}},"independent":true,"unMember":true,` +
+		`"gini":{"2018":27.7,"2019":27.6},"idd":{"root":"+4","suffixes":["7"]},"tld":[".no"],"startOfWeek":"monday",` +
+		`"postalCode":{"format":"####","regex":"^(\\d{4})$"},"status":"officially-assigned"}`
+	fixtureSweden = `{"name":{"common":"Sweden"},"cca2":"SE","cca3":"SWE","continents":["Europe"],"region":"Europe","subregion":"Northern Europe",` +
+		`"population":10353442,"area":450295,"languages":{"swe":"Swedish"},"borders":["FIN","NOR"],` +
+		`"flags":{"png":"https://flagcdn.com/w320/se.png","svg":"https://flagcdn.com/se.svg"},"capital":["Stockholm"],` +
+		`"currencies":{"SEK":{"name":"Swedish krona","symbol":"kr"}},"independent":true,"unMember":true}`
+	fixtureFinland = `{"name":{"common":"Finland"},"cca2":"FI","cca3":"FIN","continents":["Europe"],"region":"Europe","subregion":"Northern Europe",` +
+		`"population":5530719,"area":338424,"languages":{"fin":"Finnish","swe":"Swedish"},"borders":["NOR","SWE","RUS"],` +
+		`"flags":{"png":"https://flagcdn.com/w320/fi.png","svg":"https://flagcdn.com/fi.svg"},"capital":["Helsinki"],` +
+		`"currencies":{"EUR":{"name":"Euro","symbol":"€"}},"independent":true,"unMember":true}`
+	fixtureRussia = `{"name":{"common":"Russia"},"cca2":"RU","cca3":"RUS","continents":["Europe","Asia"],"region":"Europe","subregion":"Eastern Europe",` +
+		`"population":144104080,"area":17098242,"languages":{"rus":"Russian"},"borders":["FIN","NOR"],` +
+		`"flags":{"png":"https://flagcdn.com/w320/ru.png","svg":"https://flagcdn.com/ru.svg"},"capital":["Moscow"],` +
+		`"currencies":{"RUB":{"name":"Russian ruble","symbol":"₽"}},"independent":true,"unMember":true}`
+	// A dependency: not independent, no UN seat, no borders
+	fixtureGreenland = `{"name":{"common":"Greenland"},"cca2":"GL","cca3":"GRL","continents":["North America"],"region":"Americas","subregion":"North America",` +
+		`"population":56367,"area":2166086,"languages":{"kal":"Greenlandic"},"flags":{"png":"https://flagcdn.com/w320/gl.png"},` +
+		`"capital":["Nuuk"],"currencies":{"DKK":{"name":"krone","symbol":"kr."}},"independent":false,"unMember":false}`
+)
+
+// nordicFixtures is Norway with all of its neighbours.
+var nordicFixtures = []string{fixtureNorway, fixtureSweden, fixtureFinland, fixtureRussia}
+
+// nordicRates are the currency service's tables for the nordic fixtures.
+var nordicRates = map[string]map[string]float64{
+	"NOK": {"NOK": 1, "SEK": 0.98, "EUR": 0.085, "RUB": 8.1, "USD": 0.094},
+	"SEK": {"SEK": 1, "NOK": 1.02, "EUR": 0.087, "RUB": 8.3},
+	"EUR": {"EUR": 1, "NOK": 11.7, "SEK": 11.5, "RUB": 95.2},
+	"USD": {"USD": 1, "NOK": 10.6, "SEK": 10.4, "EUR": 0.92, "RUB": 88.1},
+}
+
+/* -------------------- Upstream stubs -------------------- */
+
+// upstreamStub is an httptest.Server that counts requests per path.
+type upstreamStub struct {
+	*httptest.Server
+	mu   sync.Mutex
+	hits map[string]int
+}
+
+func newUpstreamStub(t *testing.T, h http.HandlerFunc) *upstreamStub {
+	t.Helper()
+	s := &upstreamStub{hits: make(map[string]int)}
+	s.Server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		s.mu.Lock()
+		s.hits[r.URL.Path]++
+		s.mu.Unlock()
+		h(w, r)
+	}))
+	t.Cleanup(s.Close)
+	return s
+}
+
+// hitCount returns how many requests path received.
+func (s *upstreamStub) hitCount(path string) int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.hits[path]
+}
+
+// countriesStubHandler serves /alpha/{cca2|cca3} (as a one-element array),
+// /all and /currency/{code} from the given fixtures; anything else is 404.
+func countriesStubHandler(t *testing.T, fixtures ...string) http.HandlerFunc {
+	t.Helper()
+	type entry struct {
+		raw        json.RawMessage
+		cca2, cca3 string
+		currencies map[string]json.RawMessage
+	}
+	var entries []entry
+	for _, f := range fixtures {
+		var c struct {
+			CCA2       string                     `json:"cca2"`
+			CCA3       string                     `json:"cca3"`
+			Currencies map[string]json.RawMessage `json:"currencies"`
+		}
+		if err := json.Unmarshal([]byte(f), &c); err != nil {
+			t.Fatalf("bad fixture %s: %v", f, err)
+		}
+		entries = append(entries, entry{raw: json.RawMessage(f), cca2: c.CCA2, cca3: c.CCA3, currencies: c.Currencies})
+	}
+
+	return func(w http.ResponseWriter, r *http.Request) {
+		path := strings.TrimPrefix(r.URL.Path, "/")
+		var out []json.RawMessage
+		switch {
+		case path == "all":
+			for _, e := range entries {
+				out = append(out, e.raw)
+			}
+		case strings.HasPrefix(path, "alpha/"):
+			code := strings.TrimPrefix(path, "alpha/")
+			for _, e := range entries {
+				if strings.EqualFold(code, e.cca2) || strings.EqualFold(code, e.cca3) {
+					out = append(out, e.raw)
+				}
+			}
+		case strings.HasPrefix(path, "currency/"):
+			code := strings.ToUpper(strings.TrimPrefix(path, "currency/"))
+			for _, e := range entries {
+				if _, ok := e.currencies[code]; ok {
+					out = append(out, e.raw)
+				}
+			}
+		}
+		if len(out) == 0 {
+			w.WriteHeader(http.StatusNotFound)
+			_, _ = w.Write([]byte(`{"status":404,"message":"Not Found"}`))
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		_ = json.NewEncoder(w).Encode(out)
+	}
+}
+
+// currencyStubHandler serves /{BASE} in the currency service's
+// {result, base_code, rates} shape; unknown bases are 404.
+func currencyStubHandler(tables map[string]map[string]float64) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		base := strings.ToUpper(strings.TrimPrefix(r.URL.Path, "/"))
+		rates, ok := tables[base]
+		if !ok {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		_ = json.NewEncoder(w).Encode(map[string]any{"result": "success", "base_code": base, "rates": rates})
+	}
+}
+
+/* -------------------- Package state -------------------- */
+
+// useUpstreams points the package at the given stubs with DefaultConfig
+// otherwise, applies edit (if any) and starts from empty caches. Everything
+// is reset again when the test ends.
+func useUpstreams(t *testing.T, countries, currency *upstreamStub, edit func(*Config)) *Config {
+	t.Helper()
+	cfg := DefaultConfig()
+	cfg.UpstreamRetryDelay = time.Millisecond
+	cfg.RateLimitRPS = 0
+	if countries != nil {
+		cfg.CountriesBaseURL = countries.URL
+	}
+	if currency != nil {
+		cfg.CurrencyBaseURL = currency.URL
+	}
+	if edit != nil {
+		edit(cfg)
+	}
+	resetPackageState()
+	SetConfig(cfg)
+	t.Cleanup(func() {
+		resetPackageState()
+		SetConfig(DefaultConfig())
+	})
+	return cfg
+}
+
+// nordicUpstreams starts stubs serving the nordic fixtures and rates and
+// points the package at them.
+func nordicUpstreams(t *testing.T, edit func(*Config)) (countries, currency *upstreamStub) {
+	t.Helper()
+	countries = newUpstreamStub(t, countriesStubHandler(t, nordicFixtures...))
+	currency = newUpstreamStub(t, currencyStubHandler(nordicRates))
+	useUpstreams(t, countries, currency, edit)
+	return countries, currency
+}
+
+func resetTTLCache[V any](c *ttlCache[V]) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.entries = make(map[string]cacheEntry[V])
+	c.order.Init()
+}
+
+// resetPackageState empties every cache and closes the circuit breaker, so
+// tests do not see each other's data.
+func resetPackageState() {
+	resetTTLCache(countryCache)
+	resetTTLCache(ratesCache)
+	resetTTLCache(ratePairCache)
+	resetTTLCache(exchangeFullCache)
+	resetTTLCache(allCountriesCache)
+	resetTTLCache(currencyRatesCache)
+	resetTTLCache(currencyUsageCache)
+	resetTTLCache(worldStatsCache)
+	resetTTLCache(flagCache)
+
+	responseCacheOnce.Do(func() {})
+	responseCache = newLRUCache(defaultResponseCacheMax)
+
+	rateSnapshots.mu.Lock()
+	rateSnapshots.current = make(map[string]rateSnapshot)
+	rateSnapshots.previous = make(map[string]rateSnapshot)
+	rateSnapshots.mu.Unlock()
+
+	currencyBreaker.mu.Lock()
+	currencyBreaker.state, currencyBreaker.failures, currencyBreaker.trial = breakerClosed, 0, false
+	currencyBreaker.mu.Unlock()
+}
+
+/* -------------------- Requests -------------------- */
+
+// serve runs one request through h and returns the recorded response.
+func serve(h http.Handler, method, target string, header http.Header) *httptest.ResponseRecorder {
+	req := httptest.NewRequest(method, target, nil)
+	for k, v := range header {
+		req.Header[k] = v
+	}
+	rec := httptest.NewRecorder()
+	h.ServeHTTP(rec, req)
+	return rec
+}
+
+// getJSON GETs target from h, checks the status and decodes the body into out.
+func getJSON(t *testing.T, h http.HandlerFunc, target string, wantStatus int, out any) {
+	t.Helper()
+	rec := serve(h, http.MethodGet, target, nil)
+	if rec.Code != wantStatus {
+		t.Fatalf("GET %s: status %d, want %d; body %s", target, rec.Code, wantStatus, rec.Body)
+	}
+	if out != nil {
+		if err := json.Unmarshal(rec.Body.Bytes(), out); err != nil {
+			t.Fatalf("GET %s: decoding %s: %v", target, rec.Body, err)
+		}
+	}
+}
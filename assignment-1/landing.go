@@ -0,0 +1,35 @@
+package main
+
+import (
+	"embed"
+	"net/http"
+)
+
+/* -------------------- ROOT landing page -------------------- */
+
+//go:embed static/index.html
+var staticFS embed.FS
+
+// RootHandler serves the landing page at exactly "/" and a JSON 404 for any
+// other path that no API route matched.
+func RootHandler(w http.ResponseWriter, r *http.Request) {
+	if r.URL.Path != "/" {
+		writeJSONError(w, http.StatusNotFound, "not found; see / for the available endpoints")
+		return
+	}
+	if r.Method != http.MethodGet && r.Method != http.MethodHead {
+		writeJSONError(w, http.StatusMethodNotAllowed, "method not allowed")
+		return
+	}
+
+	page, err := staticFS.ReadFile("static/index.html")
+	if err != nil {
+		writeJSONError(w, http.StatusInternalServerError, "landing page unavailable")
+		return
+	}
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	w.WriteHeader(http.StatusOK)
+	if r.Method == http.MethodGet {
+		_, _ = w.Write(page)
+	}
+}
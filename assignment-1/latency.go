@@ -0,0 +1,95 @@
+package main
+
+import (
+	"math"
+	"net/http"
+	"strings"
+	"sync/atomic"
+	"time"
+)
+
+/* -------------------- UPSTREAM latency histogram -------------------- */
+
+// Upper bounds of the latency buckets; anything slower lands in the last one.
+var latencyBounds = []time.Duration{
+	100 * time.Millisecond,
+	500 * time.Millisecond,
+	time.Second,
+	5 * time.Second,
+}
+
+var latencyLabels = []string{"0-100ms", "100-500ms", "500ms-1s", "1-5s", "5s+"}
+
+// latencyHistogram counts upstream calls per duration bucket. Lock-free, so
+// recording never slows down a request.
+type latencyHistogram struct {
+	buckets [5]atomic.Int64
+}
+
+func (h *latencyHistogram) observe(d time.Duration) {
+	i := 0
+	for i < len(latencyBounds) && d >= latencyBounds[i] {
+		i++
+	}
+	h.buckets[i].Add(1)
+}
+
+type latencyBucket struct {
+	Bucket  string  `json:"bucket"`
+	Count   int64   `json:"count"`
+	Percent float64 `json:"percent"`
+}
+
+type latencySummary struct {
+	Total   int64           `json:"total"`
+	Buckets []latencyBucket `json:"buckets"`
+}
+
+func (h *latencyHistogram) summary() latencySummary {
+	var counts [5]int64
+	var total int64
+	for i := range h.buckets {
+		counts[i] = h.buckets[i].Load()
+		total += counts[i]
+	}
+	out := latencySummary{Total: total, Buckets: make([]latencyBucket, len(counts))}
+	for i, n := range counts {
+		pct := 0.0
+		if total > 0 {
+			pct = math.Round(float64(n)/float64(total)*1000) / 10
+		}
+		out.Buckets[i] = latencyBucket{Bucket: latencyLabels[i], Count: n, Percent: pct}
+	}
+	return out
+}
+
+// One histogram per upstream; flag images and anything else share "other".
+var (
+	restCountriesLatency latencyHistogram
+	currencyLatency      latencyHistogram
+	otherLatency         latencyHistogram
+)
+
+func upstreamHistogram(req *http.Request) *latencyHistogram {
+	switch upstreamName(req) {
+	case "restcountries":
+		return &restCountriesLatency
+	case "currency":
+		return &currencyLatency
+	default:
+		return &otherLatency
+	}
+}
+
+// upstreamName labels req by upstream: restcountries, currency or other.
+func upstreamName(req *http.Request) string {
+	u := req.URL.String()
+	switch {
+	case strings.HasPrefix(u, countriesBaseURL()):
+		return "restcountries"
+	case strings.HasPrefix(u, currencyBaseURL()):
+		return "currency"
+	default:
+		return "other"
+	}
+}
@@ -0,0 +1,122 @@
+package main
+
+import (
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+/* -------------------- LIST endpoint -------------------- */
+
+const maxListLimit = 250
+
+// listEntry is the trimmed per-country shape of the list endpoint.
+type listEntry struct {
+	Name       string  `json:"name"`
+	Population int64   `json:"population"`
+	Area       float64 `json:"area"`
+	Region     string  `json:"region"`
+}
+
+// listSorts is the allowlist of ?sort= values: numbers descending, names A-Z.
+var listSorts = map[string]func(a, b *countriesCountry) bool{
+	"population": func(a, b *countriesCountry) bool { return a.Population > b.Population },
+	"area":       func(a, b *countriesCountry) bool { return a.Area > b.Area },
+	"name":       func(a, b *countriesCountry) bool { return a.Name.Common < b.Name.Common },
+}
+
+// ListHandler serves /countryinfo/v1/list?minPopulation=1000000&maxArea=500000&sort=population&limit=20&independentOnly=true
+func ListHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		writeJSONError(w, http.StatusMethodNotAllowed, "method not allowed")
+		return
+	}
+	if !checkQueryParams(w, r, "minPopulation", "maxPopulation", "minArea", "maxArea", "sort", "limit", "independentOnly") {
+		return
+	}
+
+	q := r.URL.Query()
+
+	// Bounds are inclusive; an absent one does not filter
+	bounds := map[string]float64{}
+	for _, name := range []string{"minPopulation", "maxPopulation", "minArea", "maxArea"} {
+		raw := q.Get(name)
+		if raw == "" {
+			continue
+		}
+		v, err := strconv.ParseFloat(raw, 64)
+		if err != nil || v < 0 {
+			writeJSONError(w, http.StatusBadRequest, name+" must be a non-negative number")
+			return
+		}
+		bounds[name] = v
+	}
+
+	sortName := strings.ToLower(strings.TrimSpace(q.Get("sort")))
+	less, ok := listSorts[sortName]
+	if sortName != "" && !ok {
+		writeJSONError(w, http.StatusBadRequest, "sort must be one of population, area, name")
+		return
+	}
+
+	limit := 0 // everything
+	if raw := q.Get("limit"); raw != "" {
+		n, err := strconv.Atoi(raw)
+		if err != nil || n < 1 || n > maxListLimit {
+			writeJSONError(w, http.StatusBadRequest, "limit must be an integer between 1 and "+strconv.Itoa(maxListLimit))
+			return
+		}
+		limit = n
+	}
+
+	all, st, err := getAllCountries(r.Context())
+	if err != nil {
+		writeUpstreamError(w, err, "failed to call countries service")
+		return
+	}
+	if st != http.StatusOK {
+		writeJSONError(w, http.StatusBadGateway, "countries service returned non-200")
+		return
+	}
+	if independentOnly(r) {
+		all = filterIndependent(all)
+	}
+
+	// Pointers into the cached dataset; never reorder the cache itself
+	matches := make([]*countriesCountry, 0, len(all))
+	for i := range all {
+		if inListBounds(&all[i], bounds) {
+			matches = append(matches, &all[i])
+		}
+	}
+	if less != nil {
+		sort.SliceStable(matches, func(i, j int) bool { return less(matches[i], matches[j]) })
+	}
+
+	if limit > 0 && limit < len(matches) {
+		matches = matches[:limit]
+	}
+	out := make([]listEntry, 0, len(matches))
+	for _, c := range matches {
+		out = append(out, listEntry{Name: c.Name.Common, Population: c.Population, Area: c.Area, Region: c.Region})
+	}
+	writeJSON(w, http.StatusOK, out)
+}
+
+func inListBounds(c *countriesCountry, bounds map[string]float64) bool {
+	pop := float64(c.Population)
+	if v, ok := bounds["minPopulation"]; ok && pop < v {
+		return false
+	}
+	if v, ok := bounds["maxPopulation"]; ok && pop > v {
+		return false
+	}
+	if v, ok := bounds["minArea"]; ok && c.Area < v {
+		return false
+	}
+	if v, ok := bounds["maxArea"]; ok && c.Area > v {
+		return false
+	}
+	return true
+}
@@ -1,36 +1,86 @@
 package main
 
 import (
+	"context"
+	"errors"
 	"log"
 	"net/http"
 	"os"
+	"os/signal"
+	"syscall"
 	"time"
 )
 
+// How long in-flight requests (e.g. exchange calls waiting on upstreams) get
+// to finish after SIGINT or SIGTERM
+const shutdownTimeout = 10 * time.Second
+
 func main() {
-	port := os.Getenv("PORT")
-	if port == "" {
-		log.Println("$PORT has not been set. Default: 8080")
-		port = "8080"
-	}
+	cfg := LoadConfig()
 
 	startTime = time.Now()
+	log.Printf("Countries service: %s, currency service: %s", cfg.CountriesBaseURL, cfg.CurrencyBaseURL)
+
+	applyConfig(cfg)
+	initStatsD(cfg.StatsDAddr)
+	startStatusWebhook(cfg)
 
 	router := http.NewServeMux()
 
-	// Spec root paths
-	router.HandleFunc("/countryinfo/v1/status/", StatusHandler)
-	router.HandleFunc("/countryinfo/v1/info/", InfoHandler)         // expects /countryinfo/v1/info/{code}
-	router.HandleFunc("/countryinfo/v1/exchange/", ExchangeHandler) // expects /countryinfo/v1/exchange/{code}
+	// Landing page at exactly "/", JSON 404 for any unmatched path
+	router.HandleFunc("/", RootHandler)
+
+	// Spec root paths (the bare form without trailing slash redirects here)
+	handleSubtree(router, "/countryinfo/v1/status/", instrumentHandler("status", StatusHandler))
+	if cfg.EnableInfo {
+		router.HandleFunc("/countryinfo/v1/info/", instrumentHandler("info", InfoHandler)) // expects /countryinfo/v1/info/{code}
+		router.HandleFunc("/countryinfo/v1/info", InfoBatchHandler)                        // ?codes=no,se; redirects to info/ otherwise
+	}
+	if cfg.EnableExchange {
+		handleSubtree(router, "/countryinfo/v1/exchange/", instrumentHandler("exchange", ExchangeHandler)) // expects /countryinfo/v1/exchange/{code}
+	}
+	handleSubtree(router, "/countryinfo/v1/diag/", DiagHandler)
+	router.HandleFunc("/health", HealthHandler)                         // liveness
+	router.HandleFunc("/ready", ReadyHandler)                           // readiness: upstreams reachable
+	router.HandleFunc("/metrics", MetricsHandler)                       // Prometheus scrape target
+	router.HandleFunc("/countryinfo/v1/basket", BasketHandler)          // POST only
+	handleSubtree(router, "/countryinfo/v1/currency/", CurrencyHandler) // expects /countryinfo/v1/currency/{code}/rates
+
+	// Aggregates over the full countries dataset
+	router.HandleFunc("/countryinfo/v1/currency-usage", CurrencyUsageHandler)
+	router.HandleFunc("/countryinfo/v1/top", TopHandler)
+	router.HandleFunc("/countryinfo/v1/list", ListHandler)
+	router.HandleFunc("/countryinfo/v1/search", SearchHandler)              // ?name=land
+	handleSubtree(router, "/countryinfo/v1/fuzzy/", FuzzyHandler)           // expects /countryinfo/v1/fuzzy/{query}
+	handleSubtree(router, "/countryinfo/v1/world/", WorldHandler)           // expects /countryinfo/v1/world/{code}
+	handleSubtree(router, "/countryinfo/v1/neighbours/", NeighboursHandler) // expects /countryinfo/v1/neighbours/{code}
 
 	srv := &http.Server{
-		Addr:         ":" + port,
-		Handler:      router,
+		Addr:         ":" + cfg.Port,
+		Handler:      withCORS(withGzip(withRequestID(withStatsD(withUpstreamCounter(withAccessLog(withRateLimit(withSlowRequestLog(withResponseCache(withCleanPath(router)))))))))),
 		ReadTimeout:  5 * time.Second,
-		WriteTimeout: 15 * time.Second,
+		WriteTimeout: max(minWriteTimeout, cfg.ExchangeDeadline+3*time.Second), // a longer EXCHANGE_DEADLINE must still be answered
 		IdleTimeout:  60 * time.Second,
 	}
 
-	log.Println("Starting server on port " + port + " ...")
-	log.Fatal(srv.ListenAndServe())
+	stop := make(chan os.Signal, 1)
+	signal.Notify(stop, syscall.SIGINT, syscall.SIGTERM)
+	shutdownDone := make(chan struct{})
+	go func() {
+		sig := <-stop
+		log.Printf("Received %s, shutting down (waiting up to %s for active requests) ...", sig, shutdownTimeout)
+		ctx, cancel := context.WithTimeout(context.Background(), shutdownTimeout)
+		defer cancel()
+		if err := srv.Shutdown(ctx); err != nil {
+			log.Printf("Shutdown did not complete cleanly: %v", err)
+		}
+		close(shutdownDone)
+	}()
+
+	log.Println("Starting server on port " + cfg.Port + " ...")
+	if err := srv.ListenAndServe(); !errors.Is(err, http.ErrServerClosed) {
+		log.Fatal(err)
+	}
+	<-shutdownDone
+	log.Println("Server stopped")
 }
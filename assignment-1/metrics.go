@@ -0,0 +1,171 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+/* -------------------- PROMETHEUS metrics -------------------- */
+
+// Like the protobuf encoding, the Prometheus text format is written by hand
+// to stay on the standard library. Only counters and histograms are needed.
+
+// Upper bounds of the handler duration buckets, in seconds
+var durationBuckets = []float64{0.05, 0.1, 0.25, 0.5, 1, 2.5, 5, 10}
+
+// counterVec is a counter with label values joined into one key.
+type counterVec struct {
+	mu     sync.Mutex
+	values map[string]int64 // label values joined with "\x00"
+}
+
+func newCounterVec() *counterVec {
+	return &counterVec{values: make(map[string]int64)}
+}
+
+func (c *counterVec) inc(labels ...string) {
+	c.mu.Lock()
+	c.values[strings.Join(labels, "\x00")]++
+	c.mu.Unlock()
+}
+
+// histogramVec is a histogram per label value (one label).
+type histogramVec struct {
+	mu     sync.Mutex
+	series map[string]*histogramSeries
+}
+
+type histogramSeries struct {
+	buckets []int64 // per durationBuckets bound, not cumulative; +Inf is count
+	count   int64
+	sum     float64
+}
+
+func newHistogramVec() *histogramVec {
+	return &histogramVec{series: make(map[string]*histogramSeries)}
+}
+
+func (h *histogramVec) observe(label string, d time.Duration) {
+	secs := d.Seconds()
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	s, ok := h.series[label]
+	if !ok {
+		s = &histogramSeries{buckets: make([]int64, len(durationBuckets))}
+		h.series[label] = s
+	}
+	for i, bound := range durationBuckets {
+		if secs <= bound {
+			s.buckets[i]++
+			break
+		}
+	}
+	s.count++
+	s.sum += secs
+}
+
+var (
+	metricRequests         = newCounterVec()   // endpoint, code
+	metricHandlerDuration  = newHistogramVec() // endpoint
+	metricUpstreamCalls    = newCounterVec()   // upstream
+	metricUpstreamFailures = newCounterVec()   // upstream
+	metricFetches          = newCounterVec()   // helper, outcome
+)
+
+// instrumentHandler counts requests to h by status code and times them
+// under the endpoint label.
+func instrumentHandler(endpoint string, h http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		start := time.Now()
+		rec := &statusRecorder{ResponseWriter: w, status: http.StatusOK}
+		h(rec, r)
+		metricRequests.inc(endpoint, strconv.Itoa(rec.status))
+		metricHandlerDuration.observe(endpoint, time.Since(start))
+	}
+}
+
+// recordFetch counts a fetchCountryAlpha, fetchRates or
+// fetchCountriesByCurrency call by outcome:
+// ok, not_ok (a non-200 upstream status) or error.
+func recordFetch(helper string, status int, err error) {
+	outcome := "ok"
+	switch {
+	case err != nil:
+		outcome = "error"
+	case status != http.StatusOK:
+		outcome = "not_ok"
+	}
+	metricFetches.inc(helper, outcome)
+}
+
+// MetricsHandler serves /metrics in the Prometheus text exposition format.
+func MetricsHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		writeJSONError(w, http.StatusMethodNotAllowed, "method not allowed")
+		return
+	}
+
+	var b strings.Builder
+	writeCounter(&b, "countryinfo_requests_total", "Requests per endpoint and status code.", metricRequests, "endpoint", "code")
+	writeHistogram(&b, "countryinfo_handler_duration_seconds", "Handler durations per endpoint.", metricHandlerDuration, "endpoint")
+	writeCounter(&b, "countryinfo_upstream_calls_total", "Upstream HTTP calls per upstream.", metricUpstreamCalls, "upstream")
+	writeCounter(&b, "countryinfo_upstream_failures_total", "Upstream calls that failed or returned 5xx.", metricUpstreamFailures, "upstream")
+	writeCounter(&b, "countryinfo_fetches_total", "Country and rate lookups (cache included) by outcome.", metricFetches, "helper", "outcome")
+
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+	w.WriteHeader(http.StatusOK)
+	_, _ = w.Write([]byte(b.String()))
+}
+
+func writeCounter(b *strings.Builder, name, help string, c *counterVec, labelNames ...string) {
+	fmt.Fprintf(b, "# HELP %s %s\n# TYPE %s counter\n", name, help, name)
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	for _, key := range sortedKeys(c.values) {
+		fmt.Fprintf(b, "%s{%s} %d\n", name, promLabels(labelNames, strings.Split(key, "\x00")), c.values[key])
+	}
+}
+
+func writeHistogram(b *strings.Builder, name, help string, h *histogramVec, labelName string) {
+	fmt.Fprintf(b, "# HELP %s %s\n# TYPE %s histogram\n", name, help, name)
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	labels := make([]string, 0, len(h.series))
+	for l := range h.series {
+		labels = append(labels, l)
+	}
+	sort.Strings(labels)
+	for _, l := range labels {
+		s := h.series[l]
+		base := promLabels([]string{labelName}, []string{l})
+		var cumulative int64
+		for i, bound := range durationBuckets {
+			cumulative += s.buckets[i]
+			fmt.Fprintf(b, "%s_bucket{%s,le=\"%g\"} %d\n", name, base, bound, cumulative)
+		}
+		fmt.Fprintf(b, "%s_bucket{%s,le=\"+Inf\"} %d\n", name, base, s.count)
+		fmt.Fprintf(b, "%s_sum{%s} %g\n", name, base, s.sum)
+		fmt.Fprintf(b, "%s_count{%s} %d\n", name, base, s.count)
+	}
+}
+
+// promLabels formats name="value" pairs, escaping values as the format requires.
+func promLabels(names, values []string) string {
+	parts := make([]string, len(names))
+	for i, n := range names {
+		v := ""
+		if i < len(values) {
+			v = values[i]
+		}
+		v = strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`).Replace(v)
+		parts[i] = n + `="` + v + `"`
+	}
+	return strings.Join(parts, ",")
+}
@@ -0,0 +1,241 @@
+package main
+
+import (
+	"math"
+	"net/http"
+	"regexp"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+var promSampleRe = regexp.MustCompile(`^(\w+)\{(.*)\} (\S+)$`)
+
+// histogramCheck collects one histogram series' samples from /metrics.
+type histogramCheck struct {
+	bounds  []float64
+	buckets []float64
+	inf     float64
+	hasInf  bool
+	sum     float64
+	hasSum  bool
+	count   float64
+	hasCnt  bool
+}
+
+// checkExposition checks the structure of a text exposition: each family
+// has HELP then TYPE before its samples, and every histogram series has
+// cumulative buckets ending in +Inf equal to _count, plus a _sum. It
+// returns the histogram series by "family{labels without le}".
+func checkExposition(t *testing.T, body string) map[string]*histogramCheck {
+	t.Helper()
+	families := map[string]string{} // name -> type
+	var help, current, currentType string
+	histograms := map[string]*histogramCheck{}
+
+	for i, line := range strings.Split(strings.TrimSuffix(body, "\n"), "\n") {
+		switch {
+		case strings.HasPrefix(line, "# HELP "):
+			fields := strings.SplitN(line, " ", 4)
+			if len(fields) < 4 || fields[3] == "" {
+				t.Errorf("line %d: HELP without text: %q", i+1, line)
+			}
+			help = fields[2]
+			current, currentType = "", ""
+		case strings.HasPrefix(line, "# TYPE "):
+			fields := strings.Fields(line)
+			if len(fields) != 4 {
+				t.Fatalf("line %d: malformed TYPE: %q", i+1, line)
+			}
+			if fields[2] != help {
+				t.Errorf("line %d: TYPE for %s does not follow its HELP", i+1, fields[2])
+			}
+			if _, dup := families[fields[2]]; dup {
+				t.Errorf("line %d: family %s declared twice", i+1, fields[2])
+			}
+			current, currentType = fields[2], fields[3]
+			families[current] = currentType
+		default:
+			m := promSampleRe.FindStringSubmatch(line)
+			if m == nil {
+				t.Errorf("line %d: malformed sample: %q", i+1, line)
+				continue
+			}
+			name, labels := m[1], m[2]
+			value, err := strconv.ParseFloat(m[3], 64)
+			if err != nil {
+				t.Errorf("line %d: bad value: %q", i+1, line)
+				continue
+			}
+			if currentType != "histogram" {
+				if name != current {
+					t.Errorf("line %d: sample %s outside its family (current %q)", i+1, name, current)
+				}
+				continue
+			}
+
+			suffix := strings.TrimPrefix(name, current)
+			le := ""
+			if suffix == "_bucket" {
+				var rest []string
+				for _, l := range strings.Split(labels, ",") {
+					if v, ok := strings.CutPrefix(l, "le="); ok {
+						le = strings.Trim(v, `"`)
+					} else {
+						rest = append(rest, l)
+					}
+				}
+				labels = strings.Join(rest, ",")
+			}
+			key := current + "{" + labels + "}"
+			h := histograms[key]
+			if h == nil {
+				h = &histogramCheck{}
+				histograms[key] = h
+			}
+			switch suffix {
+			case "_bucket":
+				if le == "+Inf" {
+					h.inf, h.hasInf = value, true
+					continue
+				}
+				bound, err := strconv.ParseFloat(le, 64)
+				if err != nil {
+					t.Errorf("line %d: bad le: %q", i+1, line)
+					continue
+				}
+				h.bounds = append(h.bounds, bound)
+				h.buckets = append(h.buckets, value)
+			case "_sum":
+				h.sum, h.hasSum = value, true
+			case "_count":
+				h.count, h.hasCnt = value, true
+			default:
+				t.Errorf("line %d: sample %s outside its family (current %q)", i+1, name, current)
+			}
+		}
+	}
+
+	for key, h := range histograms {
+		if !h.hasInf || !h.hasSum || !h.hasCnt {
+			t.Errorf("%s: +Inf bucket %t, _sum %t, _count %t; want all", key, h.hasInf, h.hasSum, h.hasCnt)
+		}
+		for i := 1; i < len(h.buckets); i++ {
+			if h.bounds[i] <= h.bounds[i-1] {
+				t.Errorf("%s: bucket bounds not increasing: %v", key, h.bounds)
+			}
+			if h.buckets[i] < h.buckets[i-1] {
+				t.Errorf("%s: buckets not cumulative: %v", key, h.buckets)
+			}
+		}
+		if n := len(h.buckets); n > 0 && h.buckets[n-1] > h.inf {
+			t.Errorf("%s: le=%g bucket %g above +Inf %g", key, h.bounds[n-1], h.buckets[n-1], h.inf)
+		}
+		if h.inf != h.count {
+			t.Errorf("%s: +Inf bucket %g, _count %g; want equal", key, h.inf, h.count)
+		}
+	}
+	return histograms
+}
+
+// The metrics are written by hand to stay on the standard library, so the
+// exposition format is checked here instead of by the Prometheus client.
+func TestMetricsExposition(t *testing.T) {
+	nordicUpstreams(t, nil)
+	for _, target := range []string{"/countryinfo/v1/info/no", "/countryinfo/v1/info/zz", "/countryinfo/v1/exchange/no"} {
+		h := instrumentHandler("test", InfoHandler)
+		if strings.Contains(target, "exchange") {
+			h = instrumentHandler("test", ExchangeHandler)
+		}
+		serve(h, http.MethodGet, target, nil)
+	}
+
+	rec := serve(http.HandlerFunc(MetricsHandler), http.MethodGet, "/metrics", nil)
+	if rec.Code != http.StatusOK {
+		t.Fatalf("status %d, want 200", rec.Code)
+	}
+	if ct := rec.Header().Get("Content-Type"); !strings.HasPrefix(ct, "text/plain; version=0.0.4") {
+		t.Errorf("Content-Type %q", ct)
+	}
+	body := rec.Body.String()
+	histograms := checkExposition(t, body)
+
+	for _, family := range []string{
+		"countryinfo_requests_total counter",
+		"countryinfo_handler_duration_seconds histogram",
+		"countryinfo_upstream_calls_total counter",
+		"countryinfo_upstream_failures_total counter",
+		"countryinfo_fetches_total counter",
+	} {
+		if !strings.Contains(body, "# TYPE "+family+"\n") {
+			t.Errorf("missing TYPE line %q", family)
+		}
+	}
+	if h := histograms[`countryinfo_handler_duration_seconds{endpoint="test"}`]; h == nil || h.count < 3 {
+		t.Errorf("test endpoint histogram %+v, want at least 3 observations", h)
+	}
+	for _, sample := range []string{
+		`countryinfo_requests_total{endpoint="test",code="200"}`,
+		`countryinfo_requests_total{endpoint="test",code="404"}`,
+	} {
+		if !strings.Contains(body, sample+" ") {
+			t.Errorf("missing sample %s", sample)
+		}
+	}
+}
+
+// Observations land in the first bucket whose bound they do not exceed and
+// the series is written cumulatively.
+func TestHistogramBuckets(t *testing.T) {
+	tests := []struct {
+		name       string
+		durations  []time.Duration
+		wantCounts map[string]float64 // le -> cumulative count
+		wantSum    float64
+	}{
+		{
+			name:       "empty buckets below",
+			durations:  []time.Duration{3 * time.Second},
+			wantCounts: map[string]float64{"0.05": 0, "2.5": 0, "5": 1, "10": 1, "+Inf": 1},
+			wantSum:    3,
+		},
+		{
+			name:       "on a bound",
+			durations:  []time.Duration{100 * time.Millisecond, 100 * time.Millisecond},
+			wantCounts: map[string]float64{"0.05": 0, "0.1": 2, "0.25": 2, "+Inf": 2},
+			wantSum:    0.2,
+		},
+		{
+			name:       "above the last bound",
+			durations:  []time.Duration{10 * time.Millisecond, 30 * time.Second},
+			wantCounts: map[string]float64{"0.05": 1, "10": 1, "+Inf": 2},
+			wantSum:    30.01,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			h := newHistogramVec()
+			for _, d := range tt.durations {
+				h.observe(`a"b`, d)
+			}
+			var b strings.Builder
+			writeHistogram(&b, "x_seconds", "Test.", h, "endpoint")
+			body := b.String()
+
+			series := checkExposition(t, body)[`x_seconds{endpoint="a\"b"}`]
+			if series == nil {
+				t.Fatalf("series missing or label not escaped:\n%s", body)
+			}
+			if math.Abs(series.sum-tt.wantSum) > 1e-9 {
+				t.Errorf("_sum %g, want %g", series.sum, tt.wantSum)
+			}
+			for le, want := range tt.wantCounts {
+				line := `x_seconds_bucket{endpoint="a\"b",le="` + le + `"} ` + strconv.FormatFloat(want, 'g', -1, 64) + "\n"
+				if !strings.Contains(body, line) {
+					t.Errorf("missing %q in\n%s", line, body)
+				}
+			}
+		})
+	}
+}
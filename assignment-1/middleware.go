@@ -0,0 +1,186 @@
+package main
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"log"
+	"net/http"
+	"strings"
+	"time"
+)
+
+/* -------------------- Request ID -------------------- */
+
+const (
+	defaultRequestIDHeader = "X-Request-ID"
+	maxRequestIDLength     = 128
+)
+
+// withRequestID echoes the caller's request ID, or generates one if absent.
+// The header name is Config.RequestIDHeader (REQUEST_ID_HEADER).
+func withRequestID(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requestIDHeader := LoadConfig().RequestIDHeader
+		id := strings.TrimSpace(r.Header.Get(requestIDHeader))
+		if id == "" || len(id) > maxRequestIDLength {
+			id = newRequestID()
+			r.Header.Set(requestIDHeader, id)
+		}
+		w.Header().Set(requestIDHeader, id)
+		next.ServeHTTP(w, r)
+	})
+}
+
+func newRequestID() string {
+	b := make([]byte, 8)
+	if _, err := rand.Read(b); err != nil {
+		return "unknown"
+	}
+	return hex.EncodeToString(b)
+}
+
+/* -------------------- CORS -------------------- */
+
+const defaultCORSAllowOrigin = "*"
+
+// withCORS lets browser frontends call the API: every /countryinfo/ response
+// gets Access-Control-Allow-Origin (Config.CORSAllowOrigin, CORS_ALLOW_ORIGIN),
+// and OPTIONS preflights are answered with 204 before any routing.
+func withCORS(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if !strings.HasPrefix(r.URL.Path, "/countryinfo/") {
+			next.ServeHTTP(w, r)
+			return
+		}
+		cfg := LoadConfig()
+		h := w.Header()
+		h.Set("Access-Control-Allow-Origin", cfg.CORSAllowOrigin)
+		if cfg.CORSAllowOrigin != "*" {
+			h.Add("Vary", "Origin")
+		}
+		h.Set("Access-Control-Allow-Methods", "GET, HEAD, POST, OPTIONS") // POST for basket
+		h.Set("Access-Control-Allow-Headers", "Accept, Content-Type, Cache-Control, "+cfg.RequestIDHeader)
+		h.Set("Access-Control-Expose-Headers", "ETag, X-Cache, X-Upstream-Calls, "+cfg.RequestIDHeader)
+
+		if r.Method == http.MethodOptions {
+			h.Set("Access-Control-Max-Age", "600")
+			w.WriteHeader(http.StatusNoContent)
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+/* -------------------- Slow requests -------------------- */
+
+const defaultSlowRequest = 2 * time.Second
+
+// withSlowRequestLog logs requests slower than Config.SlowRequest
+// (SLOW_REQUEST_MS). It must run inside withUpstreamCounter to see the count.
+func withSlowRequestLog(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		start := time.Now()
+		next.ServeHTTP(w, r)
+		if d := time.Since(start); d > LoadConfig().SlowRequest {
+			log.Printf("WARN slow request: %s %s took %s with %d upstream calls",
+				r.Method, r.URL.Path, d.Round(time.Millisecond), upstreamCalls(r.Context()))
+		}
+	})
+}
+
+/* -------------------- Access log -------------------- */
+
+// withAccessLog logs one key=value line per request, e.g.
+//
+//	access method=GET path="/countryinfo/v1/exchange/no" status=200 bytes=412 duration_ms=183 upstream_calls=4 request_id=9f2c...
+//
+// It must run inside withRequestID and withUpstreamCounter to see both.
+func withAccessLog(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		start := time.Now()
+		rec := &accessRecorder{ResponseWriter: w, status: http.StatusOK}
+		next.ServeHTTP(rec, r)
+		log.Printf("access method=%s path=%q status=%d bytes=%d duration_ms=%d upstream_calls=%d request_id=%q",
+			r.Method, r.URL.Path, rec.status, rec.bytes, time.Since(start).Milliseconds(),
+			upstreamCalls(r.Context()), r.Header.Get(LoadConfig().RequestIDHeader))
+	})
+}
+
+// accessRecorder remembers the status code and counts the body bytes written.
+type accessRecorder struct {
+	http.ResponseWriter
+	status int
+	bytes  int
+}
+
+func (w *accessRecorder) WriteHeader(status int) {
+	w.status = status
+	w.ResponseWriter.WriteHeader(status)
+}
+
+func (w *accessRecorder) Write(b []byte) (int, error) {
+	n, err := w.ResponseWriter.Write(b)
+	w.bytes += n
+	return n, err
+}
+
+/* -------------------- Path normalization -------------------- */
+
+// withCleanPath collapses repeated slashes (/countryinfo//v1/info/no) with a
+// 308 redirect, so the method and body survive the redirect.
+func withCleanPath(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if strings.Contains(r.URL.Path, "//") {
+			u := *r.URL
+			for strings.Contains(u.Path, "//") {
+				u.Path = strings.ReplaceAll(u.Path, "//", "/")
+			}
+			u.RawPath = ""
+			http.Redirect(w, r, u.String(), http.StatusPermanentRedirect)
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// handleSubtree registers a handler for a resource root ("/.../info/") and
+// redirects the bare form without trailing slash ("/.../info") to it with 308.
+func handleSubtree(mux *http.ServeMux, root string, h http.HandlerFunc) {
+	mux.HandleFunc(root, h)
+	mux.HandleFunc(strings.TrimSuffix(root, "/"), func(w http.ResponseWriter, r *http.Request) {
+		redirectToPath(w, r, root)
+	})
+}
+
+// pathSegments splits the path after prefix into segments, ignoring one
+// trailing slash, so /countryinfo/v1/currency/eur/rates gives [eur rates]. It
+// expects between min and max segments: with fewer it writes a 400, with more
+// a 404 that names the extra segments, both naming form (the expected path),
+// and returns false. Segment contents (e.g. the code) are left to the caller,
+// so "extra path segments" and "invalid code" stay separate errors.
+func pathSegments(w http.ResponseWriter, r *http.Request, prefix string, min, max int, form string) ([]string, bool) {
+	rest := strings.TrimSuffix(strings.TrimPrefix(r.URL.Path, prefix), "/")
+	var segs []string
+	if rest != "" {
+		segs = strings.Split(rest, "/")
+	}
+	switch {
+	case len(segs) < min:
+		writeJSONError(w, http.StatusBadRequest, "missing path segment, expected "+form)
+		return nil, false
+	case len(segs) > max:
+		extra := strings.Join(segs[max:], "/")
+		writeJSONError(w, http.StatusNotFound, fmt.Sprintf("extra path segments /%s, expected %s", extra, form))
+		return nil, false
+	}
+	return segs, true
+}
+
+// redirectToPath sends a 308 to path, keeping the query string.
+func redirectToPath(w http.ResponseWriter, r *http.Request, path string) {
+	u := *r.URL
+	u.Path = path
+	u.RawPath = ""
+	http.Redirect(w, r, u.String(), http.StatusPermanentRedirect)
+}
@@ -0,0 +1,149 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"slices"
+	"testing"
+)
+
+// routedHandlers registers the status, info and exchange routes the way main
+// does, behind withCleanPath.
+func routedHandlers() http.Handler {
+	router := http.NewServeMux()
+	handleSubtree(router, "/countryinfo/v1/status/", StatusHandler)
+	router.HandleFunc("/countryinfo/v1/info/", InfoHandler)
+	router.HandleFunc("/countryinfo/v1/info", InfoBatchHandler)
+	handleSubtree(router, "/countryinfo/v1/exchange/", ExchangeHandler)
+	return withCleanPath(router)
+}
+
+// Bare roots and doubled slashes redirect with 308 to the canonical path,
+// keeping the query; one trailing slash after a code is accepted as is.
+func TestTrailingSlashRouting(t *testing.T) {
+	nordicUpstreams(t, nil)
+	h := routedHandlers()
+
+	tests := []struct {
+		target       string
+		wantStatus   int
+		wantLocation string
+	}{
+		// status
+		{"/countryinfo/v1/status", http.StatusPermanentRedirect, "/countryinfo/v1/status/"},
+		{"/countryinfo/v1/status?withTimestamp=true", http.StatusPermanentRedirect, "/countryinfo/v1/status/?withTimestamp=true"},
+		{"/countryinfo/v1/status/", http.StatusOK, ""},
+		{"/countryinfo//v1/status/", http.StatusPermanentRedirect, "/countryinfo/v1/status/"},
+		{"/countryinfo/v1/status//", http.StatusPermanentRedirect, "/countryinfo/v1/status/"},
+
+		// info
+		{"/countryinfo/v1/info", http.StatusPermanentRedirect, "/countryinfo/v1/info/"},
+		{"/countryinfo/v1/info/no", http.StatusOK, ""},
+		{"/countryinfo/v1/info/no/", http.StatusOK, ""},
+		{"/countryinfo/v1/info//no", http.StatusPermanentRedirect, "/countryinfo/v1/info/no"},
+		{"/countryinfo/v1/info/no//", http.StatusPermanentRedirect, "/countryinfo/v1/info/no/"},
+
+		// exchange
+		{"/countryinfo/v1/exchange", http.StatusPermanentRedirect, "/countryinfo/v1/exchange/"},
+		{"/countryinfo/v1/exchange?base=EUR", http.StatusPermanentRedirect, "/countryinfo/v1/exchange/?base=EUR"},
+		{"/countryinfo/v1/exchange/no", http.StatusOK, ""},
+		{"/countryinfo/v1/exchange/no/", http.StatusOK, ""},
+		{"/countryinfo/v1/exchange//no", http.StatusPermanentRedirect, "/countryinfo/v1/exchange/no"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.target, func(t *testing.T) {
+			rec := serve(h, http.MethodGet, tt.target, nil)
+			if rec.Code != tt.wantStatus {
+				t.Fatalf("status %d, want %d; body %s", rec.Code, tt.wantStatus, rec.Body)
+			}
+			if got := rec.Header().Get("Location"); got != tt.wantLocation {
+				t.Errorf("Location %q, want %q", got, tt.wantLocation)
+			}
+		})
+	}
+}
+
+// pathSegments splits what follows the prefix, ignoring one trailing slash,
+// and answers too few segments with 400 and too many with 404.
+func TestPathSegments(t *testing.T) {
+	const prefix, form = "/api/thing/", "/api/thing/{a}/{b}"
+	tests := []struct {
+		path       string
+		min, max   int
+		wantSegs   []string
+		wantStatus int // 0 when accepted
+		wantError  string
+	}{
+		{"/api/thing/x/y", 2, 2, []string{"x", "y"}, 0, ""},
+		{"/api/thing/x/y/", 2, 2, []string{"x", "y"}, 0, ""},
+		{"/api/thing/", 0, 1, nil, 0, ""},
+		{"/api/thing/x", 0, 2, []string{"x"}, 0, ""},
+		{"/api/thing/", 1, 1, nil, http.StatusBadRequest, "missing path segment, expected " + form},
+		{"/api/thing/x", 2, 2, nil, http.StatusBadRequest, "missing path segment, expected " + form},
+		{"/api/thing/x/y/z", 2, 2, nil, http.StatusNotFound, "extra path segments /z, expected " + form},
+		{"/api/thing/x/y/z/w/", 1, 1, nil, http.StatusNotFound, "extra path segments /y/z/w, expected " + form},
+	}
+	for _, tt := range tests {
+		t.Run(fmt.Sprintf("%s %d-%d", tt.path, tt.min, tt.max), func(t *testing.T) {
+			rec := httptest.NewRecorder()
+			segs, ok := pathSegments(rec, httptest.NewRequest(http.MethodGet, tt.path, nil), prefix, tt.min, tt.max, form)
+			if ok != (tt.wantStatus == 0) {
+				t.Fatalf("ok %t, want %t", ok, tt.wantStatus == 0)
+			}
+			if ok {
+				if !slices.Equal(segs, tt.wantSegs) {
+					t.Errorf("segments %q, want %q", segs, tt.wantSegs)
+				}
+				return
+			}
+			var body struct {
+				Error string `json:"error"`
+			}
+			_ = json.Unmarshal(rec.Body.Bytes(), &body)
+			if rec.Code != tt.wantStatus || body.Error != tt.wantError {
+				t.Errorf("%d %q, want %d %q", rec.Code, body.Error, tt.wantStatus, tt.wantError)
+			}
+		})
+	}
+}
+
+// Each sub-resource endpoint rejects too few and too many segments before
+// touching an upstream.
+func TestEndpointSegmentCounts(t *testing.T) {
+	countries, currency := nordicUpstreams(t, nil)
+	tests := []struct {
+		handler    http.HandlerFunc
+		target     string
+		wantStatus int
+	}{
+		{CurrencyHandler, "/countryinfo/v1/currency/", http.StatusBadRequest},
+		{CurrencyHandler, "/countryinfo/v1/currency/nok", http.StatusBadRequest},
+		{CurrencyHandler, "/countryinfo/v1/currency/nok/rates/extra", http.StatusNotFound},
+		{CurrencyHandler, "/countryinfo/v1/currency/nok/other", http.StatusNotFound},
+		{InfoHandler, "/countryinfo/v1/info/", http.StatusBadRequest},
+		{InfoHandler, "/countryinfo/v1/info/no/extra", http.StatusNotFound},
+		{ExchangeHandler, "/countryinfo/v1/exchange/", http.StatusBadRequest},
+		{ExchangeHandler, "/countryinfo/v1/exchange/no/full/extra", http.StatusNotFound},
+		{ExchangeHandler, "/countryinfo/v1/exchange/no/other", http.StatusNotFound},
+		{NeighboursHandler, "/countryinfo/v1/neighbours/", http.StatusBadRequest},
+		{NeighboursHandler, "/countryinfo/v1/neighbours/no/se", http.StatusNotFound},
+		{WorldHandler, "/countryinfo/v1/world/", http.StatusBadRequest},
+		{WorldHandler, "/countryinfo/v1/world/no/x/y", http.StatusNotFound},
+		{FuzzyHandler, "/countryinfo/v1/fuzzy/", http.StatusBadRequest},
+		{FuzzyHandler, "/countryinfo/v1/fuzzy/nor/way", http.StatusNotFound},
+	}
+	for _, tt := range tests {
+		t.Run(tt.target, func(t *testing.T) {
+			getJSON(t, tt.handler, tt.target, tt.wantStatus, nil)
+		})
+	}
+	for _, stub := range []*upstreamStub{countries, currency} {
+		stub.mu.Lock()
+		if len(stub.hits) != 0 {
+			t.Errorf("upstream called for malformed paths: %v", stub.hits)
+		}
+		stub.mu.Unlock()
+	}
+}
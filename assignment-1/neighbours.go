@@ -0,0 +1,68 @@
+package main
+
+import (
+	"errors"
+	"net/http"
+)
+
+/* -------------------- NEIGHBOURS endpoint -------------------- */
+
+// neighbourEntry is one bordering country, without any rates.
+type neighbourEntry struct {
+	Name     string `json:"name"`
+	CCA3     string `json:"cca3"`
+	Currency string `json:"currency"` // base currency, see primaryCurrency
+}
+
+// NeighboursHandler serves /countryinfo/v1/neighbours/{code}: the bordering
+// countries in border order, or an empty array for an island state.
+func NeighboursHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		writeJSONError(w, http.StatusMethodNotAllowed, "method not allowed")
+		return
+	}
+	if !checkQueryParams(w, r) {
+		return
+	}
+
+	segs, ok := pathSegments(w, r, "/countryinfo/v1/neighbours/", 1, 1, "/countryinfo/v1/neighbours/{country_code}")
+	if !ok {
+		return
+	}
+	r = withAlphaMemo(r)
+	code := normalizeISO2(segs[0])
+
+	if !validISOAlpha(code) {
+		writeJSONError(w, http.StatusBadRequest, "country code must be 2 or 3 letters (ISO 3166-1 alpha-2 or alpha-3), e.g. /countryinfo/v1/neighbours/no")
+		return
+	}
+
+	c, st, err := fetchCountryAlpha(r.Context(), code)
+	if errors.Is(err, errAmbiguousAlpha) {
+		writeJSONError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+	if err != nil {
+		writeUpstreamError(w, err, "failed to call countries service")
+		return
+	}
+	if st == http.StatusNotFound || (st == http.StatusOK && c == nil) {
+		writeJSONError(w, http.StatusNotFound, "country not found")
+		return
+	}
+	if st != http.StatusOK {
+		writeJSONError(w, http.StatusBadGateway, "countries service returned non-200")
+		return
+	}
+
+	neighbours, err := fetchNeighbours(r.Context(), c)
+	if err != nil {
+		writeUpstreamError(w, err, err.Error())
+		return
+	}
+	out := make([]neighbourEntry, 0, len(neighbours))
+	for _, nc := range neighbours {
+		out = append(out, neighbourEntry{Name: nc.Name.Common, CCA3: nc.CCA3, Currency: primaryCurrency(nc)})
+	}
+	writeJSON(w, http.StatusOK, out)
+}
@@ -0,0 +1,77 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"sort"
+	"sync"
+	"time"
+)
+
+/* -------------------- STATUS probe sampling -------------------- */
+
+const (
+	maxStatusProbeSamples = 10
+	// All samples for one status request must finish well inside WriteTimeout
+	statusProbeDeadline = 8 * time.Second
+)
+
+// probeLatency summarizes the round-trip times of several probe samples.
+type probeLatency struct {
+	MinMs    float64 `json:"min_ms"`
+	MedianMs float64 `json:"median_ms"`
+	MaxMs    float64 `json:"max_ms"`
+}
+
+// probeSampled runs n probes concurrently and returns the worst status seen
+// (any non-200 wins) plus latency statistics over all samples.
+func probeSampled(ctx context.Context, probe func(context.Context) int, n int) (int, probeLatency) {
+	n = min(max(n, 1), maxStatusProbeSamples)
+
+	ctx, cancel := context.WithTimeout(ctx, statusProbeDeadline)
+	defer cancel()
+
+	statuses := make([]int, n)
+	durations := make([]time.Duration, n)
+	var wg sync.WaitGroup
+	for i := 0; i < n; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			start := time.Now()
+			statuses[i] = probe(ctx)
+			durations[i] = time.Since(start)
+		}(i)
+	}
+	wg.Wait()
+
+	status := http.StatusOK
+	for _, st := range statuses {
+		if st != http.StatusOK {
+			status = st
+			break
+		}
+	}
+	return status, summarizeLatency(durations)
+}
+
+func summarizeLatency(durations []time.Duration) probeLatency {
+	sort.Slice(durations, func(i, j int) bool { return durations[i] < durations[j] })
+
+	var median time.Duration
+	if mid := len(durations) / 2; len(durations)%2 == 1 {
+		median = durations[mid]
+	} else {
+		median = (durations[mid-1] + durations[mid]) / 2
+	}
+	return probeLatency{
+		MinMs:    millis(durations[0]),
+		MedianMs: millis(median),
+		MaxMs:    millis(durations[len(durations)-1]),
+	}
+}
+
+// millis converts to milliseconds with microsecond precision
+func millis(d time.Duration) float64 {
+	return float64(d.Microseconds()) / 1000
+}
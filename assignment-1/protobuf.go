@@ -0,0 +1,326 @@
+package main
+
+import (
+	"encoding/binary"
+	"encoding/json"
+	"math"
+	"net/http"
+	"sort"
+	"strings"
+)
+
+/* -------------------- PROTOBUF encoding -------------------- */
+
+// Messages are defined in proto/countryinfo.proto. To stay on the standard
+// library, the wire format is written by hand: only the types the responses
+// need (varint, double, length-delimited) are supported.
+
+const protobufContentType = "application/x-protobuf"
+
+const (
+	pbVarint  = 0
+	pbFixed64 = 1
+	pbBytes   = 2
+)
+
+// protoMarshaler is implemented by responses that have a protobuf form.
+type protoMarshaler interface {
+	marshalProto() []byte
+}
+
+// wantsProtobuf reports whether the client asked for protobuf in Accept.
+func wantsProtobuf(r *http.Request) bool {
+	return acceptsMediaType(r, protobufContentType)
+}
+
+// acceptsMediaType reports whether Accept lists mediaType. Quality values
+// are ignored.
+func acceptsMediaType(r *http.Request, mediaType string) bool {
+	for _, part := range strings.Split(r.Header.Get("Accept"), ",") {
+		mt, _, _ := strings.Cut(part, ";")
+		if strings.EqualFold(strings.TrimSpace(mt), mediaType) {
+			return true
+		}
+	}
+	return false
+}
+
+// writeNegotiated writes v as protobuf or CSV when the client asked for it
+// and v supports it, and as JSON otherwise. Protobuf wins if both are asked
+// for. A HEAD request gets the same status and Content-Type without the body.
+func writeNegotiated(w http.ResponseWriter, r *http.Request, status int, v any) {
+	contentType, body := encodeNegotiated(r, v)
+	writeEncoded(w, r, status, contentType, body)
+}
+
+// encodeNegotiated picks the format for writeNegotiated and encodes v. JSON
+// matches writeJSON byte for byte, trailing newline included.
+func encodeNegotiated(r *http.Request, v any) (contentType string, body []byte) {
+	if pm, ok := v.(protoMarshaler); ok && wantsProtobuf(r) {
+		return protobufContentType, pm.marshalProto()
+	}
+	if cm, ok := v.(csvMarshaler); ok && wantsCSV(r) {
+		return csvContentType, encodeCSV(cm.marshalCSV())
+	}
+	b, err := json.Marshal(v)
+	if err != nil {
+		b = []byte(`{"error":"failed to encode response"}`)
+	}
+	return "application/json", append(b, '\n')
+}
+
+// writeEncoded sends an encoded body; a HEAD request gets only the headers.
+func writeEncoded(w http.ResponseWriter, r *http.Request, status int, contentType string, body []byte) {
+	w.Header().Set("Content-Type", contentType)
+	w.WriteHeader(status)
+	if r.Method != http.MethodHead {
+		_, _ = w.Write(body)
+	}
+}
+
+// pbBuf appends protobuf fields. Zero values are skipped as in proto3.
+type pbBuf []byte
+
+func (b *pbBuf) tag(field, wireType int) {
+	*b = binary.AppendUvarint(*b, uint64(field)<<3|uint64(wireType))
+}
+
+func (b *pbBuf) int64(field int, v int64) {
+	if v == 0 {
+		return
+	}
+	b.tag(field, pbVarint)
+	*b = binary.AppendUvarint(*b, uint64(v))
+}
+
+// optionalBool writes a set pointer even when false (proto3 optional).
+func (b *pbBuf) optionalBool(field int, v *bool) {
+	if v == nil {
+		return
+	}
+	b.tag(field, pbVarint)
+	if *v {
+		*b = append(*b, 1)
+	} else {
+		*b = append(*b, 0)
+	}
+}
+
+func (b *pbBuf) double(field int, v float64) {
+	if v == 0 {
+		return
+	}
+	b.tag(field, pbFixed64)
+	b.rawDouble(v)
+}
+
+func (b *pbBuf) rawDouble(v float64) {
+	*b = binary.LittleEndian.AppendUint64(*b, math.Float64bits(v))
+}
+
+func (b *pbBuf) bytes(field int, v []byte) {
+	b.tag(field, pbBytes)
+	*b = binary.AppendUvarint(*b, uint64(len(v)))
+	*b = append(*b, v...)
+}
+
+func (b *pbBuf) string(field int, v string) {
+	if v == "" {
+		return
+	}
+	b.bytes(field, []byte(v))
+}
+
+func (b *pbBuf) strings(field int, vs []string) {
+	for _, v := range vs {
+		b.bytes(field, []byte(v))
+	}
+}
+
+// message writes a nested message; callers skip nil ones.
+func (b *pbBuf) message(field int, m protoMarshaler) {
+	b.bytes(field, m.marshalProto())
+}
+
+// Maps are repeated entry messages with key = 1 and value = 2, written in
+// key order so the output is deterministic.
+func (b *pbBuf) stringMap(field int, m map[string]string) {
+	for _, k := range sortedKeys(m) {
+		var entry pbBuf
+		entry.string(1, k)
+		entry.string(2, m[k])
+		b.bytes(field, entry)
+	}
+}
+
+func (b *pbBuf) intMap(field int, m map[string]int) {
+	for _, k := range sortedKeys(m) {
+		var entry pbBuf
+		entry.string(1, k)
+		entry.int64(2, int64(m[k]))
+		b.bytes(field, entry)
+	}
+}
+
+func (b *pbBuf) doubleMap(field int, m map[string]float64) {
+	for _, k := range sortedKeys(m) {
+		var entry pbBuf
+		entry.string(1, k)
+		entry.double(2, m[k])
+		b.bytes(field, entry)
+	}
+}
+
+func sortedKeys[V any](m map[string]V) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+func (m *responseMeta) marshalProto() []byte {
+	var b pbBuf
+	b.stringMap(1, m.Sources)
+	b.int64(2, m.UpstreamCalls)
+	return b
+}
+
+func (g *giniValue) marshalProto() []byte {
+	var b pbBuf
+	b.int64(1, int64(g.Year))
+	b.double(2, g.Value)
+	return b
+}
+
+func (e *infoExtras) marshalProto() []byte {
+	var b pbBuf
+	if e.Gini != nil {
+		b.message(1, e.Gini)
+	}
+	b.string(2, e.CallingCode)
+	b.strings(3, e.Tld)
+	b.string(4, e.StartOfWeek)
+	if e.PostalCode != nil {
+		b.message(5, e.PostalCode)
+	}
+	b.string(6, e.Status)
+	return b
+}
+
+func (p *postalCodeFormat) marshalProto() []byte {
+	var b pbBuf
+	b.string(1, p.Format)
+	b.string(2, p.Regex)
+	return b
+}
+
+func (resp infoResponse) marshalProto() []byte {
+	var b pbBuf
+	b.string(1, resp.Name)
+	b.strings(2, resp.Continents)
+	b.int64(3, resp.Population)
+	b.double(4, resp.Area)
+	b.stringMap(5, resp.Languages)
+	b.strings(6, resp.Borders)
+	b.string(7, resp.Flag)
+	b.string(8, resp.Capital)
+	b.optionalBool(9, resp.Independent)
+	b.optionalBool(10, resp.UNMember)
+	for _, n := range resp.Neighbours {
+		b.message(11, n)
+	}
+	if resp.Extras != nil {
+		b.message(12, resp.Extras)
+	}
+	if resp.Meta != nil {
+		b.message(13, resp.Meta)
+	}
+	if resp.AreaCompare != nil {
+		b.message(14, resp.AreaCompare)
+	}
+	b.strings(15, resp.Present)
+	b.string(16, resp.Warning)
+	b.string(17, resp.GeneratedAt)
+	b.optionalBool(18, resp.Spoken)
+	b.string(19, resp.CCA2)
+	b.string(20, resp.CCA3)
+	b.string(21, resp.Region)
+	b.string(22, resp.Subregion)
+	return b
+}
+
+func (a *areaComparison) marshalProto() []byte {
+	var b pbBuf
+	b.string(1, a.Reference)
+	if a.Ratio != nil {
+		b.tag(2, pbFixed64)
+		b.rawDouble(*a.Ratio)
+	}
+	b.string(3, a.Text)
+	return b
+}
+
+func (d exchangeDetail) marshalProto() []byte {
+	var b pbBuf
+	b.string(1, d.Currency)
+	b.double(2, d.Rate)
+	b.strings(3, d.Continents)
+	b.strings(4, d.Flags)
+	return b
+}
+
+func (resp exchangeResponse) marshalProto() []byte {
+	var b pbBuf
+	b.string(1, resp.Country)
+	b.string(2, resp.BaseCurrency)
+	b.doubleMap(3, resp.ExchangeRates)
+	b.string(4, resp.Reason)
+	b.string(5, resp.Date)
+	b.string(6, resp.Warning)
+	for _, d := range resp.Details {
+		b.message(7, d)
+	}
+	if resp.Meta != nil {
+		b.message(8, resp.Meta)
+	}
+	for _, n := range resp.Neighbours {
+		b.message(9, n)
+	}
+	for _, ccy := range sortedKeys(resp.Movements) {
+		var entry pbBuf
+		entry.string(1, ccy)
+		entry.message(2, resp.Movements[ccy])
+		b.bytes(10, entry)
+	}
+	b.string(11, resp.Flag)
+	b.intMap(12, resp.CurrencyUsage)
+	b.string(13, resp.GeneratedAt)
+	b.strings(14, resp.Bases)
+	b.stringMap(15, resp.RateBases)
+	return b
+}
+
+func (m rateMovement) marshalProto() []byte {
+	var b pbBuf
+	b.string(1, m.Movement)
+	if m.ChangePercent != nil {
+		b.tag(2, pbFixed64)
+		b.rawDouble(*m.ChangePercent)
+	}
+	if m.Since != nil {
+		b.int64(3, m.Since.Unix())
+	}
+	return b
+}
+
+func (n exchangeNeighbour) marshalProto() []byte {
+	var b pbBuf
+	b.string(1, n.Name)
+	b.string(2, n.CCA3)
+	b.string(3, n.Currency)
+	b.double(4, n.Rate)
+	b.string(5, n.Flag)
+	return b
+}
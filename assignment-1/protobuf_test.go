@@ -0,0 +1,389 @@
+package main
+
+import (
+	"bufio"
+	"encoding/binary"
+	"fmt"
+	"math"
+	"net/http"
+	"os"
+	"reflect"
+	"regexp"
+	"strings"
+	"testing"
+)
+
+/* -------------------- .proto schema -------------------- */
+
+// protoField is one field of a message in proto/countryinfo.proto.
+type protoField struct {
+	name     string
+	number   int
+	typ      string // scalar type or message name; the value type for maps
+	mapKey   string // key type for map fields, "" otherwise
+	repeated bool
+}
+
+type protoSchema map[string]map[int]protoField // message -> field number -> field
+
+var (
+	protoMessageRe = regexp.MustCompile(`^message (\w+) \{$`)
+	protoFieldRe   = regexp.MustCompile(`^(repeated |optional )?(\w+|map<(\w+), (\w+)>) (\w+) = (\d+);$`)
+)
+
+// loadProtoSchema reads the flat messages of proto/countryinfo.proto; this is
+// all the file uses (no nesting, oneofs or enums).
+func loadProtoSchema(t *testing.T) protoSchema {
+	t.Helper()
+	f, err := os.Open("proto/countryinfo.proto")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+
+	schema := protoSchema{}
+	var current map[int]protoField
+	sc := bufio.NewScanner(f)
+	for sc.Scan() {
+		line := strings.TrimSpace(sc.Text())
+		if m := protoMessageRe.FindStringSubmatch(line); m != nil {
+			current = map[int]protoField{}
+			schema[m[1]] = current
+			continue
+		}
+		m := protoFieldRe.FindStringSubmatch(line)
+		if m == nil || current == nil {
+			continue
+		}
+		var number int
+		fmt.Sscan(m[6], &number)
+		field := protoField{name: m[5], number: number, typ: m[2], repeated: m[1] == "repeated "}
+		if m[3] != "" {
+			field.mapKey, field.typ = m[3], m[4]
+		}
+		if _, dup := current[number]; dup {
+			t.Fatalf("field number %d used twice", number)
+		}
+		current[number] = field
+	}
+	if err := sc.Err(); err != nil {
+		t.Fatal(err)
+	}
+	return schema
+}
+
+func (s protoSchema) fieldByName(msg, name string) (protoField, bool) {
+	for _, f := range s[msg] {
+		if f.name == name {
+			return f, true
+		}
+	}
+	return protoField{}, false
+}
+
+/* -------------------- Wire decoding -------------------- */
+
+// wireTypeOf is the wire type protobuf uses for a field of type typ.
+func (s protoSchema) wireTypeOf(typ string) int {
+	switch typ {
+	case "double":
+		return pbFixed64
+	case "int64", "int32", "bool":
+		return pbVarint
+	}
+	return pbBytes // string and messages
+}
+
+// decode reads b as message msg into a map keyed by .proto field names, with
+// JSON-like values: float64 numbers, strings, bools, []any for repeated
+// fields and map[string]any for maps and messages. A field number or wire
+// type the schema does not declare is an error.
+func (s protoSchema) decode(msg string, b []byte) (map[string]any, error) {
+	fields, ok := s[msg]
+	if !ok {
+		return nil, fmt.Errorf("unknown message %s", msg)
+	}
+	out := map[string]any{}
+	for len(b) > 0 {
+		tag, n := binary.Uvarint(b)
+		if n <= 0 {
+			return nil, fmt.Errorf("%s: bad tag", msg)
+		}
+		b = b[n:]
+		number, wireType := int(tag>>3), int(tag&7)
+		f, ok := fields[number]
+		if !ok {
+			return nil, fmt.Errorf("%s: field %d is not in the .proto", msg, number)
+		}
+		want := s.wireTypeOf(f.typ)
+		if f.mapKey != "" {
+			want = pbBytes
+		}
+		if wireType != want {
+			return nil, fmt.Errorf("%s.%s: wire type %d, want %d", msg, f.name, wireType, want)
+		}
+
+		var raw []byte
+		var scalar uint64
+		switch wireType {
+		case pbVarint:
+			scalar, n = binary.Uvarint(b)
+			if n <= 0 {
+				return nil, fmt.Errorf("%s.%s: bad varint", msg, f.name)
+			}
+			b = b[n:]
+		case pbFixed64:
+			if len(b) < 8 {
+				return nil, fmt.Errorf("%s.%s: short fixed64", msg, f.name)
+			}
+			scalar, b = binary.LittleEndian.Uint64(b), b[8:]
+		case pbBytes:
+			size, n := binary.Uvarint(b)
+			if n <= 0 || uint64(len(b)-n) < size {
+				return nil, fmt.Errorf("%s.%s: bad length", msg, f.name)
+			}
+			raw, b = b[n:n+int(size)], b[n+int(size):]
+		}
+
+		if f.mapKey != "" {
+			key, value, err := s.decodeMapEntry(f, raw)
+			if err != nil {
+				return nil, fmt.Errorf("%s.%s: %w", msg, f.name, err)
+			}
+			m, _ := out[f.name].(map[string]any)
+			if m == nil {
+				m = map[string]any{}
+				out[f.name] = m
+			}
+			m[key] = value
+			continue
+		}
+		v, err := s.decodeValue(f.typ, scalar, raw)
+		if err != nil {
+			return nil, fmt.Errorf("%s.%s: %w", msg, f.name, err)
+		}
+		if f.repeated {
+			list, _ := out[f.name].([]any)
+			out[f.name] = append(list, v)
+			continue
+		}
+		if _, dup := out[f.name]; dup {
+			return nil, fmt.Errorf("%s.%s: written twice", msg, f.name)
+		}
+		out[f.name] = v
+	}
+	return out, nil
+}
+
+func (s protoSchema) decodeValue(typ string, scalar uint64, raw []byte) (any, error) {
+	switch typ {
+	case "double":
+		return math.Float64frombits(scalar), nil
+	case "int64", "int32":
+		return float64(int64(scalar)), nil
+	case "bool":
+		return scalar != 0, nil
+	case "string":
+		return string(raw), nil
+	}
+	return s.decode(typ, raw)
+}
+
+// decodeMapEntry reads a map entry (key = 1, value = 2). A missing value is
+// the type's zero value, which proto3 does not write.
+func (s protoSchema) decodeMapEntry(f protoField, raw []byte) (string, any, error) {
+	entry := protoSchema{"entry": {
+		1: {name: "key", number: 1, typ: f.mapKey},
+		2: {name: "value", number: 2, typ: f.typ},
+	}}
+	for name, fields := range s {
+		entry[name] = fields
+	}
+	m, err := entry.decode("entry", raw)
+	if err != nil {
+		return "", nil, err
+	}
+	key, _ := m["key"].(string)
+	value, ok := m["value"]
+	if !ok {
+		switch f.typ {
+		case "string":
+			value = ""
+		case "double", "int64", "int32":
+			value = 0.0
+		default:
+			value = map[string]any{}
+		}
+	}
+	return key, value, nil
+}
+
+/* -------------------- Comparison -------------------- */
+
+// protoName maps a JSON field name to its .proto field name.
+func protoName(jsonName string) string {
+	if jsonName == "since" {
+		return "since_unix" // a timestamp in JSON, seconds in protobuf
+	}
+	return strings.ReplaceAll(strings.TrimPrefix(jsonName, "_"), "-", "_")
+}
+
+// isZero reports whether v is a value proto3 leaves out. Booleans are kept:
+// the only ones in the schema are optional, so false is written.
+func isZero(v any) bool {
+	switch v := v.(type) {
+	case nil:
+		return true
+	case string:
+		return v == ""
+	case float64:
+		return v == 0
+	case []any:
+		return len(v) == 0
+	case map[string]any:
+		for _, e := range v {
+			if !isZero(e) {
+				return false
+			}
+		}
+		return true
+	}
+	return false
+}
+
+// compareWithJSON checks that the decoded protobuf message pb carries the
+// same data as the JSON object js, field by field.
+func (s protoSchema) compareWithJSON(t *testing.T, path, msg string, js, pb map[string]any) {
+	t.Helper()
+	seen := map[string]bool{}
+	for k, jv := range js {
+		name := protoName(k)
+		f, ok := s.fieldByName(msg, name)
+		if !ok {
+			t.Errorf("%s.%s: JSON field has no counterpart in message %s", path, k, msg)
+			continue
+		}
+		seen[name] = true
+		pv := pb[name]
+		if isZero(jv) {
+			if !isZero(pv) {
+				t.Errorf("%s.%s: JSON is empty but protobuf has %v", path, k, pv)
+			}
+			continue
+		}
+		s.compareValue(t, path+"."+k, f, jv, pv)
+	}
+	for name, pv := range pb {
+		if !seen[name] && !isZero(pv) {
+			t.Errorf("%s: protobuf field %s = %v is missing from JSON", path, name, pv)
+		}
+	}
+}
+
+func (s protoSchema) compareValue(t *testing.T, path string, f protoField, jv, pv any) {
+	t.Helper()
+	_, isMessage := s[f.typ]
+	switch {
+	case f.name == "since_unix":
+		// Timestamps are formatted differently; presence is enough
+		if pv == nil {
+			t.Errorf("%s: missing in protobuf", path)
+		}
+	case f.mapKey != "":
+		jm, _ := jv.(map[string]any)
+		pm, _ := pv.(map[string]any)
+		if len(jm) != len(pm) {
+			t.Errorf("%s: %d entries in JSON, %d in protobuf", path, len(jm), len(pm))
+		}
+		for k, je := range jm {
+			elem := protoField{name: f.name, typ: f.typ}
+			s.compareValue(t, path+"["+k+"]", elem, je, pm[k])
+		}
+	case f.repeated:
+		jl, _ := jv.([]any)
+		pl, _ := pv.([]any)
+		if len(jl) != len(pl) {
+			t.Fatalf("%s: %d elements in JSON, %d in protobuf", path, len(jl), len(pl))
+		}
+		for i := range jl {
+			elem := protoField{name: f.name, typ: f.typ}
+			s.compareValue(t, fmt.Sprintf("%s[%d]", path, i), elem, jl[i], pl[i])
+		}
+	case isMessage:
+		jm, _ := jv.(map[string]any)
+		pm, _ := pv.(map[string]any)
+		s.compareWithJSON(t, path, f.typ, jm, pm)
+	default:
+		if !reflect.DeepEqual(jv, pv) {
+			t.Errorf("%s: JSON %v (%T), protobuf %v (%T)", path, jv, jv, pv, pv)
+		}
+	}
+}
+
+/* -------------------- Tests -------------------- */
+
+// protobuf.go writes the wire format by hand, so these tests decode its
+// output with the field numbers and types read from the .proto file and
+// compare it with the JSON form of the same response.
+func TestProtobufMatchesProtoSchemaAndJSON(t *testing.T) {
+	schema := loadProtoSchema(t)
+	tests := []struct {
+		msg     string
+		handler http.HandlerFunc
+		target  string
+	}{
+		{"InfoResponse", InfoHandler, "/countryinfo/v1/info/no?depth=1&meta=true&withPresence=true&extras=true&compareTo=se&withTimestamp=true"},
+		{"ExchangeResponse", ExchangeHandler, "/countryinfo/v1/exchange/no?detailed=true&meta=true&verbose=true&currencyUsage=true&withFlags=true&bases=all"},
+		{"ExchangeResponse", ExchangeHandler, "/countryinfo/v1/exchange/no?groupBy=country&include=USD"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.target, func(t *testing.T) {
+			nordicUpstreams(t, nil)
+
+			var js map[string]any
+			getJSON(t, tt.handler, tt.target, http.StatusOK, &js)
+
+			rec := serve(tt.handler, http.MethodGet, tt.target, http.Header{"Accept": {protobufContentType}})
+			if rec.Code != http.StatusOK || rec.Header().Get("Content-Type") != protobufContentType {
+				t.Fatalf("protobuf: status %d, Content-Type %q", rec.Code, rec.Header().Get("Content-Type"))
+			}
+			pb, err := schema.decode(tt.msg, rec.Body.Bytes())
+			if err != nil {
+				t.Fatalf("decoding against the .proto: %v", err)
+			}
+			delete(js, "generated_at") // the two requests are made at different times
+			delete(pb, "generated_at")
+			schema.compareWithJSON(t, tt.msg, tt.msg, js, pb)
+		})
+	}
+}
+
+// Every JSON field of the protobuf-capable responses has a .proto field, so
+// a new field cannot be added to one form only.
+func TestProtoSchemaCoversJSONFields(t *testing.T) {
+	schema := loadProtoSchema(t)
+	types := map[string]any{
+		"InfoResponse":      infoResponse{},
+		"ExchangeResponse":  exchangeResponse{},
+		"ExchangeDetail":    exchangeDetail{},
+		"ExchangeNeighbour": exchangeNeighbour{},
+		"RateMovement":      rateMovement{},
+		"AreaComparison":    areaComparison{},
+		"Extras":            infoExtras{},
+		"Gini":              giniValue{},
+		"PostalCode":        postalCodeFormat{},
+		"Meta":              responseMeta{},
+	}
+	for msg, v := range types {
+		typ := reflect.TypeOf(v)
+		for i := range typ.NumField() {
+			tag, _, _ := strings.Cut(typ.Field(i).Tag.Get("json"), ",")
+			if tag == "" || tag == "-" {
+				continue
+			}
+			if _, ok := schema.fieldByName(msg, protoName(tag)); !ok {
+				t.Errorf("%s.%s (json %q) has no field in message %s", typ.Name(), typ.Field(i).Name, tag, msg)
+			}
+		}
+	}
+}
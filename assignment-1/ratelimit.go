@@ -0,0 +1,136 @@
+package main
+
+import (
+	"math"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+/* -------------------- Per-client rate limiting -------------------- */
+
+const (
+	defaultRateLimitRPS      = 10
+	defaultRateLimitBurst    = 20
+	rateLimitCleanupInterval = time.Minute
+	maxRateLimitRPS          = 10000
+	maxRateLimitBurst        = 100000
+)
+
+// tokenBucket holds up to burst tokens and refills at rps per second; each
+// request takes one.
+type tokenBucket struct {
+	tokens float64
+	last   time.Time
+}
+
+// rateLimiter keeps one bucket per client IP. Buckets that have been idle
+// long enough to refill completely are dropped by sweep, since a new bucket
+// starts full anyway.
+type rateLimiter struct {
+	mu      sync.Mutex
+	rps     float64
+	burst   float64
+	buckets map[string]*tokenBucket
+}
+
+var (
+	limiter          atomic.Pointer[rateLimiter] // nil when RATE_LIMIT_RPS=0
+	limiterSweepOnce sync.Once
+)
+
+// configureRateLimiter replaces the limiter with a fresh one from cfg (or
+// none) and starts the sweeper on first use.
+func configureRateLimiter(cfg *Config) {
+	if cfg.RateLimitRPS == 0 {
+		limiter.Store(nil)
+		return
+	}
+	limiter.Store(&rateLimiter{
+		rps:     float64(cfg.RateLimitRPS),
+		burst:   float64(cfg.RateLimitBurst),
+		buckets: make(map[string]*tokenBucket),
+	})
+	limiterSweepOnce.Do(func() {
+		go func() {
+			ticker := time.NewTicker(rateLimitCleanupInterval)
+			defer ticker.Stop()
+			for now := range ticker.C {
+				if l := limiter.Load(); l != nil {
+					l.sweep(now)
+				}
+			}
+		}()
+	})
+}
+
+// allow takes a token for key. When none is left it returns how long until
+// the next one.
+func (l *rateLimiter) allow(key string, now time.Time) (bool, time.Duration) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	b, ok := l.buckets[key]
+	if !ok {
+		b = &tokenBucket{tokens: l.burst, last: now}
+		l.buckets[key] = b
+	}
+	b.tokens = math.Min(l.burst, b.tokens+now.Sub(b.last).Seconds()*l.rps)
+	b.last = now
+	if b.tokens >= 1 {
+		b.tokens--
+		return true, 0
+	}
+	return false, time.Duration((1 - b.tokens) / l.rps * float64(time.Second))
+}
+
+func (l *rateLimiter) sweep(now time.Time) {
+	refill := time.Duration(l.burst / l.rps * float64(time.Second))
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	for key, b := range l.buckets {
+		if now.Sub(b.last) > refill {
+			delete(l.buckets, key)
+		}
+	}
+}
+
+// withRateLimit answers 429 with Retry-After (whole seconds, at least 1) to
+// clients over Config.RateLimitRPS (RATE_LIMIT_RPS) with bursts of
+// Config.RateLimitBurst (RATE_LIMIT_BURST).
+func withRateLimit(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		l := limiter.Load()
+		if l == nil {
+			next.ServeHTTP(w, r)
+			return
+		}
+		ok, wait := l.allow(clientIP(r), time.Now())
+		if !ok {
+			w.Header().Set("Retry-After", strconv.Itoa(max(1, int(math.Ceil(wait.Seconds())))))
+			writeJSONError(w, http.StatusTooManyRequests, "rate limit exceeded, retry later")
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// clientIP is the host part of RemoteAddr or, with TRUST_FORWARDED_FOR (only
+// safe behind a proxy that sets it), the first X-Forwarded-For entry.
+func clientIP(r *http.Request) string {
+	if LoadConfig().TrustForwardedFor {
+		first, _, _ := strings.Cut(r.Header.Get("X-Forwarded-For"), ",")
+		if ip := strings.TrimSpace(first); ip != "" {
+			return ip
+		}
+	}
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return r.RemoteAddr
+	}
+	return host
+}
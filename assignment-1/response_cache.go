@@ -0,0 +1,172 @@
+package main
+
+import (
+	"bytes"
+	"container/list"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+)
+
+/* -------------------- RESPONSE cache -------------------- */
+
+const defaultResponseCacheMax = 256
+
+// Status and diagnostics must always be live.
+var uncachedPrefixes = []string{"/countryinfo/v1/status", "/countryinfo/v1/diag", "/metrics", "/health", "/ready"}
+
+type cachedResponse struct {
+	key         string
+	status      int
+	contentType string
+	etag        string // set by writeNegotiatedETag, if the handler uses it
+	body        []byte
+	expires     time.Time
+}
+
+// lruCache holds whole serialized responses, evicting the least recently
+// used entry when full.
+type lruCache struct {
+	mu    sync.Mutex
+	max   int
+	order *list.List // front = most recently used
+	items map[string]*list.Element
+}
+
+func newLRUCache(max int) *lruCache {
+	return &lruCache{max: max, order: list.New(), items: make(map[string]*list.Element)}
+}
+
+func (c *lruCache) get(key string) (*cachedResponse, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	el, ok := c.items[key]
+	if !ok {
+		return nil, false
+	}
+	resp := el.Value.(*cachedResponse)
+	if time.Now().After(resp.expires) {
+		c.order.Remove(el)
+		delete(c.items, key)
+		return nil, false
+	}
+	c.order.MoveToFront(el)
+	return resp, true
+}
+
+func (c *lruCache) set(resp *cachedResponse) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if el, ok := c.items[resp.key]; ok {
+		el.Value = resp
+		c.order.MoveToFront(el)
+		return
+	}
+	c.items[resp.key] = c.order.PushFront(resp)
+	if c.order.Len() > c.max {
+		oldest := c.order.Back()
+		c.order.Remove(oldest)
+		delete(c.items, oldest.Value.(*cachedResponse).key)
+	}
+}
+
+var (
+	responseCacheOnce sync.Once
+	responseCache     *lruCache
+)
+
+// withResponseCache serves repeated identical GETs from a cache of complete
+// responses for Config.ResponseCacheTTL (RESPONSE_CACHE_TTL; off when zero).
+// ?noCache=true or Cache-Control: no-cache bypass the lookup; the fresh
+// response still refreshes the cache.
+func withResponseCache(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		cfg := LoadConfig()
+		if cfg.ResponseCacheTTL <= 0 || r.Method != http.MethodGet || uncachedPath(r.URL.Path) {
+			next.ServeHTTP(w, r)
+			return
+		}
+		responseCacheOnce.Do(func() { responseCache = newLRUCache(cfg.ResponseCacheMax) })
+
+		// noCache is consumed here so handlers never see it
+		q := r.URL.Query()
+		bypass := q.Get("noCache") == "true" || strings.Contains(r.Header.Get("Cache-Control"), "no-cache")
+		if q.Has("noCache") {
+			q.Del("noCache")
+			u := *r.URL
+			u.RawQuery = q.Encode()
+			r2 := *r
+			r2.URL = &u
+			r = &r2
+		}
+
+		// Encode sorts the query, so parameter order does not matter
+		key := r.URL.Path + "?" + q.Encode()
+		if wantsProtobuf(r) {
+			key += "|protobuf"
+		} else if wantsCSV(r) {
+			key += "|csv"
+		}
+
+		if !bypass {
+			if hit, ok := responseCache.get(key); ok {
+				w.Header().Set("Content-Type", hit.contentType)
+				w.Header().Set("X-Cache", "HIT")
+				if hit.etag != "" {
+					w.Header().Set("ETag", hit.etag)
+					if etagMatches(r.Header.Get("If-None-Match"), hit.etag) {
+						w.WriteHeader(http.StatusNotModified)
+						return
+					}
+				}
+				w.WriteHeader(hit.status)
+				_, _ = w.Write(hit.body)
+				return
+			}
+		}
+
+		w.Header().Set("X-Cache", "MISS")
+		rec := &bodyRecorder{ResponseWriter: w, status: http.StatusOK}
+		next.ServeHTTP(rec, r)
+		// Stale answers (SERVE_STALE_ON_ERROR) must not outlive the outage
+		if rec.status == http.StatusOK && w.Header().Get("X-Cache") != "stale" {
+			responseCache.set(&cachedResponse{
+				key:         key,
+				status:      rec.status,
+				contentType: w.Header().Get("Content-Type"),
+				etag:        w.Header().Get("ETag"),
+				body:        rec.body.Bytes(),
+				expires:     time.Now().Add(cfg.ResponseCacheTTL),
+			})
+		}
+	})
+}
+
+func uncachedPath(path string) bool {
+	for _, p := range uncachedPrefixes {
+		if strings.HasPrefix(path, p) {
+			return true
+		}
+	}
+	return false
+}
+
+// bodyRecorder passes the response through and keeps a copy of it.
+type bodyRecorder struct {
+	http.ResponseWriter
+	status int
+	body   bytes.Buffer
+}
+
+func (w *bodyRecorder) WriteHeader(status int) {
+	w.status = status
+	w.ResponseWriter.WriteHeader(status)
+}
+
+func (w *bodyRecorder) Write(b []byte) (int, error) {
+	w.body.Write(b)
+	return w.ResponseWriter.Write(b)
+}
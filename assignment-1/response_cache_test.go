@@ -0,0 +1,88 @@
+package main
+
+import (
+	"net/http"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// Repeated identical requests are answered from the response cache, with
+// the content type they were first served with, until the TTL runs out.
+func TestResponseCache(t *testing.T) {
+	const ttl = 200 * time.Millisecond
+	nordicUpstreams(t, func(cfg *Config) {
+		cfg.ResponseCacheTTL = ttl
+	})
+	var calls atomic.Int64
+	h := withResponseCache(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		calls.Add(1)
+		InfoHandler(w, r)
+	}))
+	protobuf := http.Header{"Accept": {protobufContentType}}
+
+	steps := []struct {
+		name            string
+		target          string
+		header          http.Header
+		wait            time.Duration // before the request
+		wantXCache      string
+		wantCalls       int64 // handler calls so far
+		wantContentType string
+	}{
+		{"first request", "/countryinfo/v1/info/no?extras=true&meta=true", nil, 0, "MISS", 1, "application/json"},
+		{"repeated", "/countryinfo/v1/info/no?extras=true&meta=true", nil, 0, "HIT", 1, "application/json"},
+		{"query order ignored", "/countryinfo/v1/info/no?meta=true&extras=true", nil, 0, "HIT", 1, "application/json"},
+		{"other query", "/countryinfo/v1/info/no?extras=true", nil, 0, "MISS", 2, "application/json"},
+		{"protobuf is its own entry", "/countryinfo/v1/info/no?extras=true&meta=true", protobuf, 0, "MISS", 3, protobufContentType},
+		{"protobuf repeated", "/countryinfo/v1/info/no?extras=true&meta=true", protobuf, 0, "HIT", 3, protobufContentType},
+		{"noCache bypass", "/countryinfo/v1/info/no?extras=true&meta=true&noCache=true", nil, 0, "MISS", 4, "application/json"},
+		{"Cache-Control bypass", "/countryinfo/v1/info/no?extras=true&meta=true", http.Header{"Cache-Control": {"no-cache"}}, 0, "MISS", 5, "application/json"},
+		{"after the bypass", "/countryinfo/v1/info/no?extras=true&meta=true", nil, 0, "HIT", 5, "application/json"},
+		{"expired", "/countryinfo/v1/info/no?extras=true&meta=true", nil, ttl + 50*time.Millisecond, "MISS", 6, "application/json"},
+		{"errors are not cached", "/countryinfo/v1/info/zz", nil, 0, "MISS", 7, "application/json"},
+		{"errors repeated", "/countryinfo/v1/info/zz", nil, 0, "MISS", 8, "application/json"},
+	}
+	var firstBody string
+	for _, step := range steps {
+		time.Sleep(step.wait)
+		rec := serve(h, http.MethodGet, step.target, step.header)
+		if got := rec.Header().Get("X-Cache"); got != step.wantXCache {
+			t.Errorf("%s: X-Cache %q, want %q", step.name, got, step.wantXCache)
+		}
+		if got := calls.Load(); got != step.wantCalls {
+			t.Errorf("%s: handler called %d times, want %d", step.name, got, step.wantCalls)
+		}
+		if got := rec.Header().Get("Content-Type"); got != step.wantContentType {
+			t.Errorf("%s: Content-Type %q, want %q", step.name, got, step.wantContentType)
+		}
+		if step.name == "first request" {
+			firstBody = rec.Body.String()
+		} else if step.name == "repeated" && rec.Body.String() != firstBody {
+			t.Errorf("%s: cached body differs from the original", step.name)
+		}
+	}
+}
+
+// Status and diagnostics bypass the cache entirely.
+func TestResponseCacheSkipsLivePaths(t *testing.T) {
+	nordicUpstreams(t, func(cfg *Config) {
+		cfg.ResponseCacheTTL = time.Minute
+	})
+	var calls atomic.Int64
+	h := withResponseCache(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		calls.Add(1)
+		w.WriteHeader(http.StatusOK)
+	}))
+	for _, path := range []string{"/countryinfo/v1/status/", "/countryinfo/v1/diag/", "/metrics", "/health", "/ready"} {
+		before := calls.Load()
+		for range 2 {
+			if rec := serve(h, http.MethodGet, path, nil); rec.Header().Get("X-Cache") != "" {
+				t.Errorf("%s: X-Cache %q, want none", path, rec.Header().Get("X-Cache"))
+			}
+		}
+		if got := calls.Load() - before; got != 2 {
+			t.Errorf("%s: handler called %d times, want 2", path, got)
+		}
+	}
+}
@@ -0,0 +1,79 @@
+package main
+
+import (
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+/* -------------------- SEARCH endpoint -------------------- */
+
+const (
+	defaultSearchLimit = 20
+	maxSearchLimit     = 250
+)
+
+// searchEntry is one match: the code to use with info/exchange, and the name.
+type searchEntry struct {
+	CCA2 string `json:"cca2"`
+	Name string `json:"name"`
+}
+
+// SearchHandler serves /countryinfo/v1/search, e.g.
+// /countryinfo/v1/search?name=land&limit=20&independentOnly=true
+//
+// Countries whose common name contains name (case-insensitive) are returned
+// A-Z. The cached /all dataset is filtered, so a search costs no upstream
+// call once it is warm.
+func SearchHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		writeJSONError(w, http.StatusMethodNotAllowed, "method not allowed")
+		return
+	}
+	if !checkQueryParams(w, r, "name", "limit", "independentOnly") {
+		return
+	}
+
+	q := r.URL.Query()
+	name := strings.ToLower(strings.TrimSpace(q.Get("name")))
+	if name == "" {
+		writeJSONError(w, http.StatusBadRequest, "name must not be empty, e.g. /countryinfo/v1/search?name=land")
+		return
+	}
+	limit := defaultSearchLimit
+	if raw := q.Get("limit"); raw != "" {
+		n, err := strconv.Atoi(raw)
+		if err != nil || n < 1 || n > maxSearchLimit {
+			writeJSONError(w, http.StatusBadRequest, "limit must be an integer between 1 and "+strconv.Itoa(maxSearchLimit))
+			return
+		}
+		limit = n
+	}
+
+	all, st, err := getAllCountries(r.Context())
+	if err != nil {
+		writeUpstreamError(w, err, "failed to call countries service")
+		return
+	}
+	if st != http.StatusOK {
+		writeJSONError(w, http.StatusBadGateway, "countries service returned non-200")
+		return
+	}
+	if independentOnly(r) {
+		all = filterIndependent(all)
+	}
+
+	out := []searchEntry{}
+	for i := range all {
+		if strings.Contains(strings.ToLower(all[i].Name.Common), name) {
+			out = append(out, searchEntry{CCA2: strings.ToLower(all[i].CCA2), Name: all[i].Name.Common})
+		}
+	}
+	sort.Slice(out, func(i, j int) bool { return out[i].Name < out[j].Name })
+
+	if limit < len(out) {
+		out = out[:limit]
+	}
+	writeJSON(w, http.StatusOK, out)
+}
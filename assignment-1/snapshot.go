@@ -0,0 +1,108 @@
+package main
+
+import (
+	"math"
+	"sync"
+	"time"
+)
+
+/* -------------------- RATE snapshots -------------------- */
+
+const defaultSnapshotInterval = 24 * time.Hour
+
+// rateSnapshot is a rate table as fetched at a point in time. The table is
+// shared with the rates cache and never modified.
+type rateSnapshot struct {
+	table *upstreamCurrencyResponse
+	at    time.Time
+}
+
+// snapshotStore keeps, per base currency, the latest snapshot and the one
+// before it. A new snapshot is taken at most once per Config.SnapshotInterval
+// (SNAPSHOT_INTERVAL), so "previous" is roughly one interval old.
+type snapshotStore struct {
+	mu       sync.Mutex
+	current  map[string]rateSnapshot
+	previous map[string]rateSnapshot
+}
+
+var rateSnapshots = &snapshotStore{
+	current:  make(map[string]rateSnapshot),
+	previous: make(map[string]rateSnapshot),
+}
+
+// record offers a freshly fetched current table for base.
+func (s *snapshotStore) record(base string, table *upstreamCurrencyResponse) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	now := time.Now()
+	cur, ok := s.current[base]
+	if !ok {
+		s.current[base] = rateSnapshot{table: table, at: now}
+		return
+	}
+	if now.Sub(cur.at) >= LoadConfig().SnapshotInterval {
+		s.previous[base] = cur
+		s.current[base] = rateSnapshot{table: table, at: now}
+	}
+}
+
+// baseline returns the previous snapshot for base, if one exists yet.
+func (s *snapshotStore) baseline(base string) (rateSnapshot, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	snap, ok := s.previous[base]
+	return snap, ok
+}
+
+/* -------------------- RATE movement (exchange ?classify=true) -------------------- */
+
+const (
+	movementStronger   = "stronger"
+	movementWeaker     = "weaker"
+	movementUnchanged  = "unchanged"
+	movementNoBaseline = "no_baseline"
+
+	// Changes smaller than this (in percent) count as unchanged
+	movementTolerancePct = 0.01
+)
+
+// rateMovement describes how a currency moved against the base since the
+// baseline snapshot.
+type rateMovement struct {
+	Movement      string     `json:"movement"`
+	ChangePercent *float64   `json:"change_percent,omitempty"` // change of the rate itself
+	Since         *time.Time `json:"since,omitempty"`          // when the baseline was taken
+}
+
+// classifyRates compares each returned rate with the baseline of base. A
+// higher rate means one unit of base buys more of the currency, so the
+// currency got weaker relative to the base.
+func classifyRates(base string, rates map[string]float64) map[string]rateMovement {
+	out := make(map[string]rateMovement, len(rates))
+	snap, ok := rateSnapshots.baseline(base)
+
+	for ccy, now := range rates {
+		then, found := 0.0, false
+		if ok {
+			then, found = snap.table.Rates[ccy]
+		}
+		if !found || then == 0 {
+			out[ccy] = rateMovement{Movement: movementNoBaseline}
+			continue
+		}
+
+		pct := math.Round((now-then)/then*100*100) / 100
+		m := rateMovement{Movement: movementUnchanged, ChangePercent: &pct, Since: &snap.at}
+		switch {
+		case pct > movementTolerancePct:
+			m.Movement = movementWeaker
+		case pct < -movementTolerancePct:
+			m.Movement = movementStronger
+		}
+		out[ccy] = m
+	}
+	return out
+}
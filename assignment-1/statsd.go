@@ -0,0 +1,79 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"net"
+	"net/http"
+	"time"
+)
+
+/* -------------------- STATSD -------------------- */
+
+// statsdPrefix namespaces every metric this service pushes.
+const statsdPrefix = "countryinfo."
+
+// statsdClient pushes counters and timers over UDP. A nil client is a no-op,
+// so call sites never check whether StatsD is configured.
+type statsdClient struct {
+	conn net.Conn
+}
+
+var statsd *statsdClient
+
+// initStatsD connects to addr (host:port). Empty addr leaves StatsD disabled.
+func initStatsD(addr string) {
+	if addr == "" {
+		return
+	}
+	conn, err := net.Dial("udp", addr)
+	if err != nil {
+		log.Printf("StatsD disabled, cannot dial %q: %v", addr, err)
+		return
+	}
+	statsd = &statsdClient{conn: conn}
+}
+
+func (s *statsdClient) send(line string) {
+	if s == nil {
+		return
+	}
+	// UDP is fire-and-forget; a lost metric must never affect a request
+	_, _ = s.conn.Write([]byte(statsdPrefix + line))
+}
+
+// incr adds one to a counter.
+func (s *statsdClient) incr(name string) {
+	s.send(name + ":1|c")
+}
+
+// timing records a duration in milliseconds.
+func (s *statsdClient) timing(name string, d time.Duration) {
+	s.send(fmt.Sprintf("%s:%d|ms", name, d.Milliseconds()))
+}
+
+// withStatsD counts requests per status class and times each request.
+func withStatsD(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if statsd == nil {
+			next.ServeHTTP(w, r)
+			return
+		}
+		start := time.Now()
+		rec := &statusRecorder{ResponseWriter: w, status: http.StatusOK}
+		next.ServeHTTP(rec, r)
+		statsd.incr(fmt.Sprintf("requests.%dxx", rec.status/100))
+		statsd.timing("requests.duration", time.Since(start))
+	})
+}
+
+// statusRecorder remembers the status code written by the handler.
+type statusRecorder struct {
+	http.ResponseWriter
+	status int
+}
+
+func (w *statusRecorder) WriteHeader(status int) {
+	w.status = status
+	w.ResponseWriter.WriteHeader(status)
+}
@@ -0,0 +1,58 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"sync"
+	"time"
+)
+
+/* -------------------- RESPONSE timestamps -------------------- */
+
+// fetchClock tracks the oldest upstream data a request has used. Cached
+// countries and rate tables carry the time they were downloaded, so a
+// response built from cache reports that time rather than the moment it was
+// serialized.
+type fetchClock struct {
+	mu     sync.Mutex
+	oldest time.Time
+}
+
+// withFetchClock attaches a fetchClock to the request's context; country and
+// rate lookups made with it report their fetch times to the clock.
+func withFetchClock(r *http.Request) (*http.Request, *fetchClock) {
+	clock := &fetchClock{}
+	return r.WithContext(context.WithValue(r.Context(), fetchClockKey, clock)), clock
+}
+
+// observeFetch records that data fetched at t was used under ctx. It is a
+// no-op without a clock or for a zero time.
+func observeFetch(ctx context.Context, t time.Time) {
+	clock, ok := ctx.Value(fetchClockKey).(*fetchClock)
+	if !ok || t.IsZero() {
+		return
+	}
+	clock.mu.Lock()
+	defer clock.mu.Unlock()
+	if clock.oldest.IsZero() || t.Before(clock.oldest) {
+		clock.oldest = t
+	}
+}
+
+// generatedAt returns the RFC3339 timestamp for a response when the client
+// asked for one with ?withTimestamp=true or RESPONSE_TIMESTAMPS is on, and ""
+// otherwise. Without a clock or any observed fetch (e.g. live probes) it is now.
+func generatedAt(r *http.Request, c *fetchClock) string {
+	if r.URL.Query().Get("withTimestamp") != "true" && !LoadConfig().ResponseTimestamps {
+		return ""
+	}
+	t := time.Now()
+	if c != nil {
+		c.mu.Lock()
+		if !c.oldest.IsZero() {
+			t = c.oldest
+		}
+		c.mu.Unlock()
+	}
+	return t.UTC().Format(time.RFC3339)
+}
@@ -0,0 +1,99 @@
+package main
+
+import (
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+/* -------------------- TOP endpoint -------------------- */
+
+const (
+	defaultTopLimit = 10
+	maxTopLimit     = 250
+)
+
+// topMetrics is the allowlist of ?metric= values and how to read each one.
+var topMetrics = map[string]func(c *countriesCountry) float64{
+	"population": func(c *countriesCountry) float64 { return float64(c.Population) },
+	"area":       func(c *countriesCountry) float64 { return c.Area },
+	"density":    func(c *countriesCountry) float64 { return density(c.Population, c.Area) },
+}
+
+// TopHandler serves /countryinfo/v1/top?metric=population&limit=10&order=desc&independentOnly=true
+func TopHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		writeJSONError(w, http.StatusMethodNotAllowed, "method not allowed")
+		return
+	}
+	if !checkQueryParams(w, r, "metric", "limit", "order", "independentOnly") {
+		return
+	}
+
+	q := r.URL.Query()
+
+	metricName := strings.ToLower(strings.TrimSpace(q.Get("metric")))
+	if metricName == "" {
+		metricName = "population"
+	}
+	metric, ok := topMetrics[metricName]
+	if !ok {
+		writeJSONError(w, http.StatusBadRequest, "metric must be one of population, area, density")
+		return
+	}
+
+	order := strings.ToLower(strings.TrimSpace(q.Get("order")))
+	if order == "" {
+		order = "desc"
+	}
+	if order != "asc" && order != "desc" {
+		writeJSONError(w, http.StatusBadRequest, "order must be asc or desc")
+		return
+	}
+
+	limit := defaultTopLimit
+	if raw := q.Get("limit"); raw != "" {
+		n, err := strconv.Atoi(raw)
+		if err != nil || n < 1 || n > maxTopLimit {
+			writeJSONError(w, http.StatusBadRequest, "limit must be an integer between 1 and "+strconv.Itoa(maxTopLimit))
+			return
+		}
+		limit = n
+	}
+
+	all, st, err := getAllCountries(r.Context())
+	if err != nil {
+		writeUpstreamError(w, err, "failed to call countries service")
+		return
+	}
+	if st != http.StatusOK {
+		writeJSONError(w, http.StatusBadGateway, "countries service returned non-200")
+		return
+	}
+
+	if independentOnly(r) {
+		all = filterIndependent(all)
+	}
+
+	// Sort pointers into the cached dataset; never reorder the cache itself
+	ranked := make([]*countriesCountry, 0, len(all))
+	for i := range all {
+		ranked = append(ranked, &all[i])
+	}
+	sort.SliceStable(ranked, func(i, j int) bool {
+		if order == "asc" {
+			return metric(ranked[i]) < metric(ranked[j])
+		}
+		return metric(ranked[i]) > metric(ranked[j])
+	})
+
+	if limit < len(ranked) {
+		ranked = ranked[:limit]
+	}
+	out := make([]infoResponse, 0, len(ranked))
+	for _, c := range ranked {
+		out = append(out, toInfoResponse(c))
+	}
+	writeJSON(w, http.StatusOK, out)
+}
@@ -0,0 +1,220 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"strconv"
+	"sync/atomic"
+	"time"
+)
+
+/* -------------------- UPSTREAM calls -------------------- */
+
+type ctxKey int
+
+const (
+	upstreamCallsKey ctxKey = iota
+	staleServedKey
+	fetchClockKey
+	alphaMemoKey
+	singleAttemptKey
+)
+
+// doUpstream performs every outgoing upstream request, so per-request
+// accounting lives in one place.
+func doUpstream(client *http.Client, req *http.Request) (*http.Response, error) {
+	if n, ok := req.Context().Value(upstreamCallsKey).(*atomic.Int64); ok {
+		n.Add(1)
+	}
+	statsd.incr("upstream.calls")
+	start := time.Now()
+	resp, err := client.Do(req)
+	elapsed := time.Since(start)
+	statsd.timing("upstream.duration", elapsed)
+	upstreamHistogram(req).observe(elapsed)
+	upstream := upstreamName(req)
+	metricUpstreamCalls.inc(upstream)
+	if err != nil {
+		statsd.incr("upstream.errors")
+	}
+	if err != nil || resp.StatusCode >= http.StatusInternalServerError {
+		metricUpstreamFailures.inc(upstream)
+	}
+	return resp, err
+}
+
+const (
+	defaultUpstreamTimeout = 5 * time.Second
+	// The exchange answer must still fit inside the server's WriteTimeout
+	defaultExchangeDeadline = 12 * time.Second
+	minWriteTimeout         = 15 * time.Second
+)
+
+const (
+	defaultUpstreamAttempts   = 3
+	maxUpstreamAttempts       = 10
+	defaultUpstreamRetryDelay = 100 * time.Millisecond
+	// Retries for one call must end well inside the server's WriteTimeout
+	upstreamRetryBudget = 12 * time.Second
+)
+
+// upstreamCallBudget bounds one upstream call with its retries when it runs
+// on its own context: UPSTREAM_TIMEOUT per attempt, within
+// upstreamRetryBudget.
+func upstreamCallBudget(cfg *Config) time.Duration {
+	return min(time.Duration(cfg.UpstreamAttempts)*cfg.UpstreamTimeout, upstreamRetryBudget)
+}
+
+// withSingleAttempt makes doUpstreamRetry calls made with the returned
+// context try only once, e.g. for probes that time one round trip.
+func withSingleAttempt(ctx context.Context) context.Context {
+	return context.WithValue(ctx, singleAttemptKey, true)
+}
+
+// doUpstreamRetry is doUpstream with retries for transient failures: network
+// errors and 5xx responses (except 501, which will not change). It makes up
+// to Config.UpstreamAttempts attempts, doubling Config.UpstreamRetryDelay
+// after each one. An attempt is only started if it can finish, client
+// timeout included, before the request's deadline or upstreamRetryBudget.
+func doUpstreamRetry(client *http.Client, req *http.Request) (*http.Response, error) {
+	cfg := LoadConfig()
+	ctx := req.Context()
+	deadline := time.Now().Add(upstreamRetryBudget)
+	if d, ok := ctx.Deadline(); ok && d.Before(deadline) {
+		deadline = d
+	}
+
+	attempts := cfg.UpstreamAttempts
+	if single, _ := ctx.Value(singleAttemptKey).(bool); single {
+		attempts = 1
+	}
+
+	delay := cfg.UpstreamRetryDelay
+	for attempt := 1; ; attempt++ {
+		resp, err := doUpstream(client, req)
+		if !retryableUpstream(resp, err) || attempt >= attempts ||
+			time.Now().Add(delay+client.Timeout).After(deadline) {
+			return resp, err
+		}
+		if resp != nil {
+			resp.Body.Close()
+		}
+		statsd.incr("upstream.retries")
+
+		timer := time.NewTimer(delay)
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return nil, ctx.Err()
+		case <-timer.C:
+		}
+		delay *= 2
+	}
+}
+
+func retryableUpstream(resp *http.Response, err error) bool {
+	if err != nil {
+		return !errors.Is(err, context.Canceled) // the client went away
+	}
+	return resp.StatusCode >= http.StatusInternalServerError && resp.StatusCode != http.StatusNotImplemented
+}
+
+// upstreamCalls returns how many upstream requests were made so far with ctx
+// (cache hits do not count).
+func upstreamCalls(ctx context.Context) int64 {
+	if n, ok := ctx.Value(upstreamCallsKey).(*atomic.Int64); ok {
+		return n.Load()
+	}
+	return 0
+}
+
+// withUpstreamCounter attaches a call counter to each request and reports it
+// in the X-Upstream-Calls response header.
+func withUpstreamCounter(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		ctx := context.WithValue(r.Context(), upstreamCallsKey, new(atomic.Int64))
+		r = r.WithContext(ctx)
+		next.ServeHTTP(&upstreamCountWriter{ResponseWriter: w, ctx: ctx}, r)
+	})
+}
+
+// upstreamCountWriter sets the header just before the status line goes out,
+// when the handler has finished its upstream calls.
+type upstreamCountWriter struct {
+	http.ResponseWriter
+	ctx         context.Context
+	wroteHeader bool
+}
+
+func (w *upstreamCountWriter) WriteHeader(status int) {
+	if !w.wroteHeader {
+		w.wroteHeader = true
+		w.Header().Set("X-Upstream-Calls", strconv.FormatInt(upstreamCalls(w.ctx), 10))
+	}
+	w.ResponseWriter.WriteHeader(status)
+}
+
+func (w *upstreamCountWriter) Write(b []byte) (int, error) {
+	if !w.wroteHeader {
+		w.WriteHeader(http.StatusOK)
+	}
+	return w.ResponseWriter.Write(b)
+}
+
+// Stages reported in 502 bodies, so a malformed answer is not mistaken for
+// an unreachable service
+const (
+	stageTransport = "transport"
+	stageDecode    = "decode"
+	stageDeadline  = "deadline"
+)
+
+// upstreamDecodeError is returned when an upstream answered 200 with a body
+// that is not the expected JSON, e.g. an HTML error page.
+type upstreamDecodeError struct {
+	service string // "countries" or "currency"
+	err     error
+}
+
+func (e *upstreamDecodeError) Error() string {
+	return e.service + " service returned malformed JSON"
+}
+
+func (e *upstreamDecodeError) Unwrap() error { return e.err }
+
+// upstreamErrorMessage returns the decode message for a decode failure and
+// msg for anything else.
+func upstreamErrorMessage(err error, msg string) string {
+	var de *upstreamDecodeError
+	if errors.As(err, &de) {
+		return de.Error()
+	}
+	return msg
+}
+
+// upstreamFailure prefixes err with msg and keeps it wrapped, so
+// writeUpstreamError still finds a decode failure (502, stage decode) or a
+// passed deadline (504) behind it.
+func upstreamFailure(err error, msg string) error {
+	return fmt.Errorf("%s: %w", msg, err)
+}
+
+// writeUpstreamError answers a failed upstream call with 502 and the stage
+// it failed at; msg is used unless the body could not be decoded. A call
+// that ran out of time (UPSTREAM_TIMEOUT or EXCHANGE_DEADLINE) gets 504.
+func writeUpstreamError(w http.ResponseWriter, err error, msg string) {
+	if errors.Is(err, context.DeadlineExceeded) {
+		writeJSON(w, http.StatusGatewayTimeout, errResp{Error: "upstream services did not answer in time", Stage: stageDeadline})
+		return
+	}
+	var de *upstreamDecodeError
+	if errors.As(err, &de) {
+		log.Printf("WARN %v: %v", de, de.err)
+		writeJSON(w, http.StatusBadGateway, errResp{Error: de.Error(), Stage: stageDecode})
+		return
+	}
+	writeJSON(w, http.StatusBadGateway, errResp{Error: msg, Stage: stageTransport})
+}
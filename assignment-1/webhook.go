@@ -0,0 +1,102 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"log"
+	"net/http"
+	"time"
+)
+
+/* -------------------- STATUS webhook -------------------- */
+
+const (
+	defaultStatusPollInterval = time.Minute
+	// A new status must be seen this many polls in a row before it is
+	// reported, so a single failed probe does not cause two notifications.
+	statusDebouncePolls = 2
+
+	statusOK       = "ok"
+	statusDegraded = "degraded"
+)
+
+type statusChange struct {
+	Previous  string    `json:"previous"`
+	Current   string    `json:"current"`
+	Timestamp time.Time `json:"timestamp"`
+}
+
+// startStatusWebhook polls both upstreams in the background and POSTs to
+// STATUS_WEBHOOK_URL whenever the overall status changes. No-op when unset.
+func startStatusWebhook(cfg *Config) {
+	if cfg.StatusWebhookURL == "" {
+		return
+	}
+	go func() {
+		current := statusOK // assume healthy at startup; only changes are reported
+		candidate, seen := current, 0
+
+		ticker := time.NewTicker(cfg.StatusPollInterval)
+		defer ticker.Stop()
+		for range ticker.C {
+			st := pollUpstreamStatus()
+			if st == current {
+				candidate, seen = current, 0
+				continue
+			}
+			if st != candidate {
+				candidate, seen = st, 0
+			}
+			seen++
+			if seen < statusDebouncePolls {
+				continue
+			}
+
+			change := statusChange{Previous: current, Current: st, Timestamp: time.Now().UTC()}
+			current, seen = st, 0
+			if err := postStatusChange(cfg.StatusWebhookURL, change); err != nil {
+				log.Printf("status webhook: %v", err)
+			}
+		}
+	}()
+}
+
+// pollUpstreamStatus is the overall status as the status endpoint sees it.
+func pollUpstreamStatus() string {
+	ctx, cancel := context.WithTimeout(context.Background(), statusProbeDeadline)
+	defer cancel()
+
+	probeRest, probeCurrency := upstreamsInUse(LoadConfig())
+	if probeRest && probeRestCountries(ctx) != http.StatusOK {
+		return statusDegraded
+	}
+	if probeCurrency && probeCurrencyService(ctx) != http.StatusOK {
+		return statusDegraded
+	}
+	return statusOK
+}
+
+func postStatusChange(url string, change statusChange) error {
+	body, err := json.Marshal(change)
+	if err != nil {
+		return err
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), statusProbeDeadline)
+	defer cancel()
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, url, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	resp, err := httpClient.Do(req)
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	if resp.StatusCode >= 300 {
+		log.Printf("status webhook answered %d", resp.StatusCode)
+	}
+	return nil
+}
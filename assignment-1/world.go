@@ -0,0 +1,161 @@
+package main
+
+import (
+	"math"
+	"net/http"
+	"sort"
+	"strconv"
+	"time"
+)
+
+/* -------------------- WORLD comparison endpoint -------------------- */
+
+type worldMetric struct {
+	Value        float64 `json:"value"`
+	WorldAverage float64 `json:"world_average"`
+	Delta        float64 `json:"delta"`      // value - world_average
+	Percentile   float64 `json:"percentile"` // share of countries with a lower value, 0-100
+}
+
+type worldCompareResponse struct {
+	Name       string      `json:"name"`
+	Population worldMetric `json:"population"`
+	Area       worldMetric `json:"area"`
+	Density    worldMetric `json:"density"` // people per km²
+}
+
+// worldStats holds one sorted distribution per metric, derived from /all.
+type worldStats struct {
+	population []float64
+	area       []float64
+	density    []float64
+}
+
+// Global averages move far slower than any single request cares about.
+var worldStatsCache = newTTLCache[*worldStats](6*time.Hour, 1)
+
+// WorldHandler serves /countryinfo/v1/world/{code}
+func WorldHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		writeJSONError(w, http.StatusMethodNotAllowed, "method not allowed")
+		return
+	}
+	if !checkQueryParams(w, r) {
+		return
+	}
+
+	segs, ok := pathSegments(w, r, "/countryinfo/v1/world/", 1, 1, "/countryinfo/v1/world/{two_letter_country_code}")
+	if !ok {
+		return
+	}
+	code := normalizeISO2(segs[0])
+
+	if !validISO2(code) {
+		writeJSONError(w, http.StatusBadRequest, "two_letter_country_code must be 2 letters (ISO 3166-2), e.g. /countryinfo/v1/world/no")
+		return
+	}
+
+	c, st, err := fetchCountryAlpha(r.Context(), code)
+	if err != nil {
+		writeUpstreamError(w, err, "failed to call countries service")
+		return
+	}
+	if st == http.StatusNotFound || (st == http.StatusOK && c == nil) {
+		writeJSONError(w, http.StatusNotFound, "country not found")
+		return
+	}
+	if st != http.StatusOK {
+		writeJSONError(w, http.StatusBadGateway, "countries service returned non-200")
+		return
+	}
+
+	stats, ok := worldStatsCache.get("world")
+	if !ok {
+		all, st, err := getAllCountries(r.Context())
+		if err != nil {
+			writeUpstreamError(w, err, "failed to call countries service")
+			return
+		}
+		if st != http.StatusOK {
+			writeJSONError(w, http.StatusBadGateway, "countries service returned non-200")
+			return
+		}
+		stats = computeWorldStats(all)
+		worldStatsCache.set("world", stats)
+	}
+
+	out := worldCompareResponse{
+		Name:       c.Name.Common,
+		Population: compareMetric(float64(c.Population), stats.population),
+		Area:       compareMetric(c.Area, stats.area),
+		Density:    compareMetric(density(c.Population, c.Area), stats.density),
+	}
+	writeJSON(w, http.StatusOK, out)
+}
+
+func density(population int64, area float64) float64 {
+	if area <= 0 {
+		return 0
+	}
+	return float64(population) / area
+}
+
+func computeWorldStats(all []countriesCountry) *worldStats {
+	s := &worldStats{}
+	for _, c := range all {
+		s.population = append(s.population, float64(c.Population))
+		if c.Area > 0 {
+			s.area = append(s.area, c.Area)
+			s.density = append(s.density, density(c.Population, c.Area))
+		}
+	}
+	sort.Float64s(s.population)
+	sort.Float64s(s.area)
+	sort.Float64s(s.density)
+	return s
+}
+
+// compareMetric places v within a sorted distribution.
+func compareMetric(v float64, sorted []float64) worldMetric {
+	if len(sorted) == 0 {
+		return worldMetric{Value: v}
+	}
+
+	sum := 0.0
+	for _, x := range sorted {
+		sum += x
+	}
+	avg := sum / float64(len(sorted))
+	below := sort.SearchFloat64s(sorted, v)
+
+	return worldMetric{
+		Value:        round2(v),
+		WorldAverage: round2(avg),
+		Delta:        round2(v - avg),
+		Percentile:   round2(100 * float64(below) / float64(len(sorted))),
+	}
+}
+
+func round2(v float64) float64 {
+	return math.Round(v*100) / 100
+}
+
+/* -------------------- AREA comparison (info ?compareTo=) -------------------- */
+
+// areaComparison relates a country's area to a familiar reference country.
+type areaComparison struct {
+	Reference string   `json:"reference"`
+	Ratio     *float64 `json:"ratio"`          // null when either area is unknown
+	Text      string   `json:"text,omitempty"` // e.g. "0.59x the size of France"
+}
+
+func compareArea(c, ref *countriesCountry) *areaComparison {
+	out := &areaComparison{Reference: ref.Name.Common}
+	if c.Area <= 0 || ref.Area <= 0 {
+		return out
+	}
+	ratio := round2(c.Area / ref.Area)
+	out.Ratio = &ratio
+	out.Text = strconv.FormatFloat(ratio, 'f', -1, 64) + "x the size of " + ref.Name.Common
+	return out
+}